@@ -0,0 +1,101 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"testing/fstest"
+)
+
+// openArchive opens a .zip, .tar.gz/.tgz, or .tar file as a filesystem. Zip
+// files are read in place; tarballs can't be read at random, so they're
+// loaded into memory.
+func openArchive(name string) (fs.FS, error) {
+	lower := strings.ToLower(name)
+	var fsys fs.FS
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		// Kept open for as long as the app serves it
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, err
+		}
+		fsys = zr
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"), strings.HasSuffix(lower, ".tar"):
+		m, err := readTar(name, !strings.HasSuffix(lower, ".tar"))
+		if err != nil {
+			return nil, err
+		}
+		fsys = m
+	default:
+		return nil, errors.New("unsupported archive type (use .zip, .tar.gz, .tgz, or .tar)")
+	}
+	return archiveRoot(fsys), nil
+}
+
+// readTar loads the regular files of a tarball into an in-memory
+// filesystem. fstest.MapFS is used as a plain map-backed fs.FS; it fills in
+// the folders implied by file paths.
+func readTar(name string, gzipped bool) (fstest.MapFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	files := fstest.MapFS{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Skip links, devices, and paths that escape the archive
+		p := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if hdr.Typeflag != tar.TypeReg || !fs.ValidPath(p) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		files[p] = &fstest.MapFile{Data: data, Mode: 0o444, ModTime: hdr.ModTime}
+	}
+}
+
+// archiveRoot steps into the archive's only top-level folder when the app
+// isn't at the root, as when zipping a dist or build folder itself.
+func archiveRoot(fsys fs.FS) fs.FS {
+	if _, err := fs.Stat(fsys, cfg.Index); err == nil {
+		return fsys
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return fsys
+	}
+	sub, err := fs.Sub(fsys, entries[0].Name())
+	if err != nil {
+		return fsys
+	}
+	return sub
+}
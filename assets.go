@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// hashAssets maps each file in fsys to a hash of its content.
+func hashAssets(fsys fs.FS) map[string]string {
+	hashes := make(map[string]string)
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if data, err := fs.ReadFile(fsys, name); err == nil {
+			sum := sha256.Sum256(data)
+			hashes[name] = hex.EncodeToString(sum[:8])
+		}
+		return nil
+	})
+	return hashes
+}
+
+// assetHashes lists the active embedded build's asset hashes as JSON, for
+// build tooling that wants to write versioned URLs itself. Other sites have
+// none, so a file of theirs by the same name goes to next.
+func assetHashes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hashes := activeSite.Load().hashes
+		if hashes == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(hashes)
+	})
+}
+
+// assetRef matches src and href attributes holding a plain path, that is
+// without a scheme, query, or fragment.
+var assetRef = regexp.MustCompile(`\b(src|href)="([^"?#:]+)"`)
+
+// versionedAssets marks requests carrying the asset's current hash as
+// ?v=<hash> immutable, so even files without a fingerprint in their name can
+// be cached forever. The index page is served with its references rewritten
+// to such URLs.
+func versionedAssets(fsys fs.FS, hashes map[string]string, next http.Handler) http.Handler {
+	index, err := fs.ReadFile(fsys, cfg.Index)
+	if err != nil {
+		return next
+	}
+	index = assetRef.ReplaceAllFunc(index, func(m []byte) []byte {
+		sub := assetRef.FindSubmatch(m)
+		ref := strings.TrimPrefix(string(sub[2]), cfg.BasePath)
+		hash, ok := hashes[strings.TrimPrefix(path.Clean("/"+ref), "/")]
+		if !ok {
+			return m
+		}
+		return []byte(string(sub[1]) + `="` + string(sub[2]) + "?v=" + hash + `"`)
+	})
+	sum := sha256.Sum256(index)
+	indexTag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		switch {
+		case name == "" || name == cfg.Index:
+			w.Header().Set("ETag", indexTag)
+			http.ServeContent(w, r, cfg.Index, time.Time{}, bytes.NewReader(index))
+			return
+		case r.URL.Query().Get("v") != "" && r.URL.Query().Get("v") == hashes[name]:
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+)
+
+// printBanner summarizes the effective configuration in one block at
+// startup, so a misconfigured run is obvious without reading every flag.
+func printBanner(port, tlsPort int) {
+	var b strings.Builder
+	b.WriteString("Configuration:\n")
+
+	listen := fmt.Sprintf("%s, port %d", cmp.Or(cfg.Bind, "all interfaces"), port)
+	tlsMode := "off"
+	cert := "self-signed"
+	if cfg.TLSCertFile != "" {
+		cert = "certificate " + cfg.TLSCertFile
+	}
+	switch {
+	case tlsPort != 0:
+		listen += fmt.Sprintf(" (HTTPS on %d)", tlsPort)
+		tlsMode = cert + ", on port " + fmt.Sprint(tlsPort)
+	case cfg.TLS:
+		tlsMode = cert
+	}
+	if cfg.UnixSocket != "" {
+		listen = "unix socket " + cfg.UnixSocket
+	}
+	fmt.Fprintf(&b, "  Listening: %s\n", listen)
+	fmt.Fprintf(&b, "  TLS:       %s\n", tlsMode)
+	fmt.Fprintf(&b, "  Auth:      %s\n", onOff(cfg.Auth != ""))
+
+	var sources []string
+	for _, s := range sites {
+		switch {
+		case s.name == "embedded":
+			sources = append(sources, "embedded build ("+embeddedBuildVersion()+")")
+		case s.hashes != nil:
+			sources = append(sources, "embedded app "+s.name)
+		case s.dir != "":
+			sources = append(sources, "folder "+s.dir)
+		default:
+			sources = append(sources, "archive "+s.name)
+		}
+	}
+	fmt.Fprintf(&b, "  Serving:   %s\n", strings.Join(sources, ", "))
+
+	var features []string
+	add := func(on bool, name string) {
+		if on {
+			features = append(features, name)
+		}
+	}
+	add(cfg.Brotli, "brotli")
+	add(cfg.Gzip, "gzip")
+	add(cfg.Precompressed, "precompressed")
+	add(cfg.H2C, "h2c")
+	add(cfg.DualStack, "separate IPv4 and IPv6 listeners")
+	add(cfg.CORS != "", "cors "+cfg.CORS)
+	add(cfg.Rate > 0, fmt.Sprintf("rate limit %g/s", cfg.Rate))
+	add(cfg.ServerTiming, "Server-Timing")
+	add(cfg.Throttle > 0, fmt.Sprintf("throttled to %d bytes/s", cfg.Throttle))
+	add(cfg.MaxConns > 0, fmt.Sprintf("max %d concurrent", cfg.MaxConns))
+	add(len(cfg.AllowCIDRs) > 0, "allow "+strings.Join(cfg.AllowCIDRs, ","))
+	add(len(cfg.Headers) > 0, fmt.Sprintf("%d custom headers", len(cfg.Headers)))
+	add(len(cfg.RuntimeConfig) > 0, fmt.Sprintf("%d runtime config values", len(cfg.RuntimeConfig)))
+	add(len(cfg.Redirects) > 0, fmt.Sprintf("%d redirects", len(cfg.Redirects)))
+	for _, p := range cfg.Proxies {
+		features = append(features, "proxy "+p.Prefix+" → "+p.Target.String())
+	}
+	add(cfg.LiveReload && len(cfg.Dirs) > 0, "live reload")
+	add(cfg.WatchRestart && len(cfg.Dirs) > 0, "restart on change")
+	add(cfg.Listing, "listing")
+	add(cfg.NoDirRedirect, "no folder redirects")
+	add(cfg.StrictPaths, "strict paths")
+	add(cfg.AssetVersions, "asset versions")
+	add(cfg.Index != "index.html", "index "+cfg.Index)
+	add(len(cfg.LangIndexes) > 0, fmt.Sprintf("%d localized indexes", len(cfg.LangIndexes)))
+	if cfg.Token != "" {
+		add(true, "unlisted link")
+	} else {
+		add(cfg.BasePath != "", "base path "+cfg.BasePath)
+	}
+	add(cfg.MDNSName != "", "mDNS "+cfg.MDNSName+".local")
+	add(cfg.WiFiSSID != "", "Wi-Fi QR "+cfg.WiFiSSID)
+	add(cfg.ShutdownToken != "", "shutdown endpoint")
+	if _, preset := cspPresets[strings.ToLower(cfg.CSP)]; !preset {
+		add(true, "custom CSP")
+	} else {
+		add(!strings.EqualFold(cfg.CSP, "off"), "CSP "+strings.ToLower(cfg.CSP))
+	}
+	add(cfg.NoSymlinks, "no symlinks out of folders")
+	add(cfg.WSEcho, "WebSocket echo")
+	add(cfg.Events, "events at /events")
+	add(cfg.Info, "info at /__info")
+	add(cfg.OnConnect != "", "on-connect hook")
+	add(strings.TrimSpace(cfg.Exec) != "", "exec "+cfg.Exec)
+	add(cfg.Uploads != "", "uploads to "+cfg.Uploads)
+	add(cfg.Verbose, "request log")
+	add(cfg.LowPower, "low power")
+	fmt.Fprintf(&b, "  Features:  %s\n", cmp.Or(strings.Join(features, ", "), "none"))
+
+	sayf("%s", b.String())
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// copyToClipboard puts text on the system clipboard using the platform's
+// clipboard tool.
+func copyToClipboard(text string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("clip")
+	case "darwin":
+		cmd = exec.Command("pbcopy")
+	default:
+		var err error
+		if cmd, err = linuxClipboard(""); err != nil {
+			return err
+		}
+	}
+
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v %s", cmd.Args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// copyImageToClipboard puts a PNG image, not a path to one, on the system
+// clipboard. macOS and Windows have no stdin-based tool for images, so the
+// PNG goes through a temporary file there.
+func copyImageToClipboard(png []byte) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		f, err := os.CreateTemp("", "dapptoon-clip-*.png")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(png)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+
+		if runtime.GOOS == "darwin" {
+			cmd = exec.Command("osascript", "-e",
+				fmt.Sprintf("set the clipboard to (read (POSIX file %q) as «class PNGf»)", f.Name()))
+		} else {
+			// The WinForms clipboard needs a single-threaded apartment, and a
+			// quote in the path, as in C:\Users\O'Brien, must be doubled
+			cmd = exec.Command("powershell", "-NoProfile", "-STA", "-Command",
+				"Add-Type -AssemblyName System.Windows.Forms, System.Drawing; "+
+					"[System.Windows.Forms.Clipboard]::SetImage([System.Drawing.Image]::FromFile('"+strings.ReplaceAll(f.Name(), "'", "''")+"'))")
+		}
+	default:
+		var err error
+		if cmd, err = linuxClipboard("image/png"); err != nil {
+			return err
+		}
+		cmd.Stdin = bytes.NewReader(png)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v %s", cmd.Args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// loggedClipboardTool makes linuxClipboard report its choice only once.
+var loggedClipboardTool sync.Once
+
+// linuxClipboard returns a command that copies stdin to the clipboard, as
+// mimeType if set. Under Wayland there's often no X server for xclip to
+// talk to, so wl-copy comes first there; xsel is the last resort and only
+// handles text.
+func linuxClipboard(mimeType string) (*exec.Cmd, error) {
+	tools := []string{"xclip", "xsel"}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = []string{"wl-copy", "xclip", "xsel"}
+	}
+
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			continue
+		}
+		var cmd *exec.Cmd
+		switch tool {
+		case "wl-copy":
+			cmd = exec.Command("wl-copy")
+			if mimeType != "" {
+				cmd.Args = append(cmd.Args, "--type", mimeType)
+			}
+		case "xclip":
+			cmd = exec.Command("xclip", "-selection", "clipboard")
+			if mimeType != "" {
+				cmd.Args = append(cmd.Args, "-t", mimeType)
+			}
+		case "xsel":
+			if mimeType != "" {
+				continue
+			}
+			cmd = exec.Command("xsel", "--clipboard", "--input")
+		}
+		loggedClipboardTool.Do(func() { log.Println("Using", tool, "for the clipboard") })
+		return cmd, nil
+	}
+
+	what := "text"
+	if mimeType != "" {
+		what = mimeType
+	}
+	warnf("No clipboard tool for %s found (tried %s)", what, strings.Join(tools, ", "))
+	return nil, errors.New("no clipboard tool found (install wl-clipboard, xclip, or xsel)")
+}
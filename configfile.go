@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// loadSettings fills in the flags not given on the command line, first from
+// the -config file and then from DAPPTOON_* environment variables, so the
+// precedence is defaults < environment < config file < flags. The file can
+// itself come from DAPPTOON_CONFIG. It returns the environment variables
+// that were applied.
+func loadSettings(configPath *string) ([]string, error) {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if path := os.Getenv("DAPPTOON_CONFIG"); path != "" && !set["config"] {
+		*configPath = path
+	}
+	if *configPath != "" {
+		if err := loadConfigFile(*configPath, set); err != nil {
+			return nil, fmt.Errorf("invalid -config: %w", err)
+		}
+	}
+
+	var applied []string
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || f.Name == "config" {
+			return
+		}
+		key := envName(f.Name)
+		v, ok := os.LookupEnv(key)
+		if !ok {
+			return
+		}
+		if e := flag.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("invalid %s: %w", key, e)
+		}
+		applied = append(applied, key)
+	})
+	return applied, err
+}
+
+// envName is the environment variable for a flag, e.g. DAPPTOON_TLS_PORT
+// for -tls-port.
+func envName(flagName string) string {
+	return "DAPPTOON_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfigFile applies settings from a JSON file keyed by flag name, e.g.
+//
+//	{"port": 8080, "dir": ["app=./build"], "auth": "me:secret", "allow": ["192.168.1.0/24"]}
+//
+// Flags set explicitly on the command line win over the file, so the flags
+// in set are skipped; the ones the file sets are added to it. Lists set
+// repeatable flags once per entry.
+func loadConfigFile(path string, set map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Keep numbers as written; large ones would otherwise print as 1e+06
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Apply in a stable order so repeatable flags behave predictably
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if flag.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if set[name] {
+			continue
+		}
+		set[name] = true
+
+		list, ok := values[name].([]any)
+		if !ok {
+			list = []any{values[name]}
+		}
+		for _, v := range list {
+			if v == nil {
+				continue
+			}
+			if err := flag.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// plainOutput keeps console output to plain ASCII, for -no-color, NO_COLOR,
+// and output that isn't a terminal, like CI logs, files, and old Windows
+// consoles that garble anything else.
+var plainOutput bool
+
+// setPlainOutput decides plainOutput once flags are parsed.
+func setPlainOutput(noColor bool) {
+	plainOutput = noColor || os.Getenv("NO_COLOR") != "" || !isTerminal(os.Stdout)
+}
+
+// isTerminal reports whether f is a console rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// asciiReplacer spells out the non-ASCII characters the console output uses.
+var asciiReplacer = strings.NewReplacer("→", "->", "—", "-", "–", "-", "…", "...", "“", `"`, "”", `"`, "‘", "'", "’", "'")
+
+// console returns s as it should be printed, with anything non-ASCII left
+// after asciiReplacer shown as "?" when plainOutput is set.
+func console(s string) string {
+	if !plainOutput {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if r > 0x7f {
+			return '?'
+		}
+		return r
+	}, asciiReplacer.Replace(s))
+}
+
+// say prints a line to the console, like fmt.Println.
+func say(a ...any) {
+	fmt.Print(console(fmt.Sprintln(a...)))
+}
+
+// sayf prints to the console like fmt.Printf.
+func sayf(format string, a ...any) {
+	fmt.Print(console(fmt.Sprintf(format, a...)))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// deviceTracker remembers which client IPs have made requests recently, so
+// a device is only announced once per burst of asset requests.
+type deviceTracker struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	window time.Duration
+	onNew  func(ip string)
+}
+
+func newDeviceTracker(window time.Duration, onNew func(ip string)) *deviceTracker {
+	return &deviceTracker{seen: make(map[string]time.Time), window: window, onNew: onNew}
+}
+
+// observe records a request from ip and reports whether the device is new,
+// i.e. hasn't been seen within the window.
+func (t *deviceTracker) observe(ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	last, ok := t.seen[ip]
+	t.seen[ip] = now
+	if ok && now.Sub(last) < t.window {
+		return false
+	}
+
+	// Prune stale entries only when something changes, to keep the map small
+	for k, v := range t.seen {
+		if now.Sub(v) >= t.window {
+			delete(t.seen, k)
+		}
+	}
+	return true
+}
+
+// middleware calls onNew for each newly observed client. Loopback requests
+// are the local browser, so they aren't announced.
+func (t *deviceTracker) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r); ip != nil && !ip.IsLoopback() && t.observe(ip.String()) {
+			go t.onNew(ip.String())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
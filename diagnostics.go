@@ -0,0 +1,124 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// problem is a warning or error worth showing a user who never sees the
+// log.
+type problem struct {
+	at  time.Time
+	msg string
+}
+
+// problemLog keeps the latest warnings and errors, oldest first, for the
+// tray's Diagnostics submenu and Copy Server Info.
+var problemLog struct {
+	sync.Mutex
+	recent []problem
+}
+
+// maxProblems is how many problems are kept.
+const maxProblems = 10
+
+// problemsChanged signals the Diagnostics submenu that a problem was added.
+var problemsChanged = make(chan struct{}, 1)
+
+// warn logs msg and keeps it for the Diagnostics submenu.
+func warn(msg string) {
+	log.Println(msg)
+	problemLog.Lock()
+	problemLog.recent = append(problemLog.recent, problem{time.Now(), msg})
+	if len(problemLog.recent) > maxProblems {
+		problemLog.recent = problemLog.recent[1:]
+	}
+	problemLog.Unlock()
+	select {
+	case problemsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// warnf is warn with formatting, like log.Printf.
+func warnf(format string, a ...any) {
+	warn(fmt.Sprintf(format, a...))
+}
+
+// recentProblems returns up to n of the latest problems, newest first.
+func recentProblems(n int) []problem {
+	problemLog.Lock()
+	defer problemLog.Unlock()
+	var latest []problem
+	for i := len(problemLog.recent) - 1; i >= 0 && len(latest) < n; i-- {
+		latest = append(latest, problemLog.recent[i])
+	}
+	return latest
+}
+
+// diagnostics gathers what's needed to troubleshoot a connection problem
+// into text that can be pasted into a bug report.
+func diagnostics() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Dapptoon: %s\n", appVersion())
+	fmt.Fprintf(&b, "OS: %s/%s (%s)\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+
+	srvMu.Lock()
+	port, tlsPort := boundPort, boundTLSPort
+	srvMu.Unlock()
+	fmt.Fprintf(&b, "Port: %d\n", port)
+	if tlsPort != 0 {
+		fmt.Fprintf(&b, "HTTPS port: %d\n", tlsPort)
+	}
+	fmt.Fprintf(&b, "Bind: %s\n", cmp.Or(bindAddr(), "all interfaces"))
+
+	v4, v6 := currentLANIPs()
+	fmt.Fprintf(&b, "LAN IP: %s (detected now: %s)\n", cmp.Or(v4, "none"), cmp.Or(getLANIP(), "none"))
+	if v6 != "" {
+		fmt.Fprintf(&b, "LAN IPv6: %s\n", v6)
+	}
+	url, _ := currentLANURLs()
+	fmt.Fprintf(&b, "LAN URL: %s\n", cmp.Or(url, "none"))
+	fmt.Fprintf(&b, "Firewall blocking LAN: %t\n", firewallBlocked.Load())
+	fmt.Fprintf(&b, "Paused: %t\n", paused.Load())
+
+	s := activeSite.Load()
+	fmt.Fprintf(&b, "Serving: %s", s.name)
+	if s.dir != "" {
+		fmt.Fprintf(&b, " (%s)", s.dir)
+	}
+	b.WriteString("\n")
+	_, err := fs.Stat(distFiles, path.Join("dist", cfg.Index))
+	fmt.Fprintf(&b, "Embedded %s: %t (build %s)\n", cfg.Index, err == nil, embeddedBuildVersion())
+
+	if recent := recentProblems(maxProblems); len(recent) > 0 {
+		b.WriteString("Recent problems:\n")
+		for _, p := range recent {
+			fmt.Fprintf(&b, "  %s %s\n", p.at.Format(time.TimeOnly), p.msg)
+		}
+	}
+
+	b.WriteString("Interfaces:\n")
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		fmt.Fprintf(&b, "  error: %v\n", err)
+	}
+	for _, iface := range ifaces {
+		var addrs []string
+		if as, err := iface.Addrs(); err == nil {
+			for _, a := range as {
+				addrs = append(addrs, a.String())
+			}
+		}
+		fmt.Fprintf(&b, "  %s [%s] %s\n", iface.Name, iface.Flags, strings.Join(addrs, " "))
+	}
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// dryRun checks the configuration and prints what a real run would serve,
+// at which URLs, and what the QR code would encode, without binding any
+// port or showing the tray. It returns the exit code: 1 if it found a
+// problem, or exitNoBuild when -strict finds nothing to serve.
+func dryRun() int {
+	var problems []string
+	if _, err := regexp.Compile(cfg.CachePattern); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid -cache-pattern: %v", err))
+	}
+	if _, err := parseCIDRs(cfg.AllowCIDRs); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid -allow network: %v", err))
+	}
+
+	var err error
+	sites, err = loadSites(cfg.Apps, cfg.Dirs, cfg.Archives)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, s := range sites {
+		// -listing serves folders without an index page on purpose
+		if cfg.Listing {
+			break
+		}
+		if f, err := s.root.Open("/" + cfg.Index); err != nil {
+			problems = append(problems, fmt.Sprintf("%s has no %s", s.name, cfg.Index))
+		} else {
+			f.Close()
+		}
+	}
+	if len(sites) > 0 {
+		activeSite.Store(sites[0])
+		printBanner(cfg.Port, cfg.TLSPort)
+	}
+
+	urlScheme, urlPort = "http", cfg.Port
+	if cfg.TLSPort != 0 {
+		urlScheme, urlPort, httpPort = "https", cfg.TLSPort, cfg.Port
+	} else if cfg.TLS {
+		urlScheme = "https"
+	}
+	if cfg.UnixSocket != "" {
+		say("Would serve at: unix:" + cfg.UnixSocket)
+	} else {
+		setLANIPs(lanIPs())
+		localURL = appURL(hostURL(urlScheme, localHost(), urlPort))
+		url, url6 := currentLANURLs()
+		say("Local URL:", localURL)
+		say("LAN URL:  ", cmp.Or(url, "none"))
+		if url6 != "" && url6 != url {
+			say("IPv6 URL: ", url6)
+		}
+		if httpURL := currentHTTPURL(); httpURL != "" {
+			say("HTTP URL: ", httpURL)
+		}
+		if localOnly() && cfg.PublicURL == "" {
+			problems = append(problems, "listening on "+cfg.Bind+" only, so other devices can't connect")
+		}
+	}
+	qr := shareURL()
+	say("QR code:  ", cmp.Or(qr, "none"))
+	if qr == "" {
+		problems = append(problems, "no LAN IP, so there's nothing for the QR code to encode")
+	}
+
+	if len(problems) == 0 {
+		say("No problems found")
+		return 0
+	}
+	for _, p := range problems {
+		say("Problem:", p)
+	}
+	if errors.Is(err, errNoBuild) {
+		return exitNoBuild
+	}
+	return 1
+}
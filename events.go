@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventHub fans -events messages out to every connected page.
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[chan string]struct{}
+}
+
+// appEvents carries the messages POST /broadcast sends to /events.
+var appEvents = &eventHub{clients: make(map[chan string]struct{})}
+
+func (h *eventHub) subscribe() chan string {
+	// Room for a burst of messages while the client catches up
+	ch := make(chan string, 16)
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan string) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+}
+
+// disconnectAll ends every stream, like reloadHub's.
+func (h *eventHub) disconnectAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		close(ch)
+		delete(h.clients, ch)
+	}
+}
+
+// broadcast queues msg for every client, returning how many got it. A
+// client too far behind misses it rather than holding up the rest.
+func (h *eventHub) broadcast(msg string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sent := 0
+	for ch := range h.clients {
+		select {
+		case ch <- msg:
+			sent++
+		default:
+		}
+	}
+	return sent
+}
+
+// serveEvents streams each broadcast message as a server-sent event, which
+// pages read with new EventSource("/events").
+func (h *eventHub) serveEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	ch := h.subscribe()
+	defer h.unsubscribe(ch)
+
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			// Each line of a multi-line message needs its own data field
+			fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(msg, "\n", "\ndata: "))
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveBroadcast sends the request body to every /events client. Only this
+// computer may send, so other devices on the LAN can't drive the demo.
+func serveBroadcast(w http.ResponseWriter, r *http.Request) {
+	if !clientIP(r).IsLoopback() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg := strings.TrimRight(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	n := appEvents.broadcast(msg)
+	if verboseLogs.Load() {
+		log.Printf("Broadcast %q to %d clients", msg, n)
+	}
+	fmt.Fprintf(w, "Sent to %d clients\n", n)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// firewallBlocked is set while the OS firewall appears to block other
+// devices from reaching the server.
+var firewallBlocked atomic.Bool
+
+// watchFirewall rechecks the firewall every interval, since the user may
+// answer the Windows prompt, or change the rule, while the app runs. Only
+// a change is reported, so a blocked LAN is pointed out once.
+func watchFirewall(interval time.Duration) {
+	// Only Windows prompts per program, so only there can it be checked
+	if runtime.GOOS != "windows" || localOnly() {
+		return
+	}
+	for ; ; time.Sleep(interval) {
+		blocked, ok := firewallBlocks()
+		if !ok || blocked == firewallBlocked.Load() {
+			continue
+		}
+		firewallBlocked.Store(blocked)
+		if blocked {
+			msg := "The firewall may be blocking other devices; " + localURL + " still works on this computer"
+			warn(msg)
+			notify(msg)
+		} else {
+			log.Println("The firewall now allows other devices to connect")
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// firewallBlocks reports whether Windows Firewall has an enabled inbound
+// rule blocking this program, as declining its prompt creates, and none
+// allowing it. ok is false when that couldn't be determined.
+func firewallBlocks() (blocked, ok bool) {
+	exe, err := os.Executable()
+	if err != nil {
+		return false, false
+	}
+	script := `Get-NetFirewallApplicationFilter -Program '` + strings.ReplaceAll(exe, "'", "''") + `' | Get-NetFirewallRule | ` +
+		`Where-Object { $_.Enabled -eq 'True' -and $_.Direction -eq 'Inbound' } | ForEach-Object { $_.Action }`
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	// Don't flash a console window every time this runs
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	if err != nil {
+		return false, false
+	}
+	actions := strings.Fields(string(out))
+	blocks := false
+	for _, a := range actions {
+		switch a {
+		case "Allow":
+			return false, true
+		case "Block":
+			blocks = true
+		}
+	}
+	return blocks, true
+}
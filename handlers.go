@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// customHeader is a response header given with -header.
+type customHeader struct {
+	Name, Value string
+}
+
+// parseHeader parses "Name: Value", rejecting names that aren't valid
+// header tokens and values with line breaks, which could inject headers.
+func parseHeader(v string) (customHeader, error) {
+	name, value, ok := strings.Cut(v, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || name == "" {
+		return customHeader{}, errors.New(`expected "Name: Value"`)
+	}
+	if strings.ContainsFunc(name, func(r rune) bool {
+		return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+	}) {
+		return customHeader{}, fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return customHeader{}, fmt.Errorf("header %s: value can't contain line breaks", name)
+	}
+	return customHeader{Name: http.CanonicalHeaderKey(name), Value: value}, nil
+}
+
+// cspPresets are the -csp policies for a typical Vite or React build: its
+// own scripts, styles, and fonts, images from data: and blob: URLs, and
+// fetches back to the server. relaxed also allows inline scripts and styles,
+// as CSS-in-JS and some analytics snippets need, and HTTPS and WebSocket
+// backends elsewhere.
+var cspPresets = map[string]string{
+	"strict":  "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data: blob:; font-src 'self'; connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+	"relaxed": "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data: blob: https:; font-src 'self' data: https:; connect-src 'self' https: ws: wss:; object-src 'none'; base-uri 'self'; frame-ancestors 'self'",
+	"off":     "",
+}
+
+// cspPolicy resolves -csp to a Content-Security-Policy value: a preset's
+// policy, or v itself when it's a policy written out, like
+// "default-src 'self'". A single word must name a preset, so typos fail.
+func cspPolicy(v string) (string, error) {
+	if policy, ok := cspPresets[strings.ToLower(v)]; ok {
+		return policy, nil
+	}
+	if !strings.ContainsAny(v, " ;") {
+		return "", fmt.Errorf("unknown preset %q: expected strict, relaxed, off, or a policy", v)
+	}
+	if strings.ContainsAny(v, "\r\n") {
+		return "", errors.New("policy can't contain line breaks")
+	}
+	return v, nil
+}
+
+// customHeaders sets the -header headers on every response.
+func customHeaders(headers []customHeader, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range headers {
+			w.Header().Set(h.Name, h.Value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody rejects request bodies over max bytes with 413. Nothing served
+// here reads a body, so a declared length over the limit is refused up
+// front; the reader is capped too, for bodies sent without one. Paths
+// under exempt, like -proxy backends that take uploads, are let through.
+func limitBody(max int64, exempt []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range exempt {
+			if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if r.ContentLength > max {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestIDEncoding renders request IDs as short lowercase base32.
+var requestIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// requestID tags each request with an X-Request-Id, echoed in the response
+// and the access log and passed on to -proxy backends. A well-formed ID
+// the client sent is kept, so its errors can be matched to the log.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = requestIDEncoding.EncodeToString(b)
+			r.Header.Set("X-Request-Id", id)
+		}
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether a client's ID is short and plain enough to
+// put in a log line.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	return !strings.ContainsFunc(id, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("-_.", r))
+	})
+}
+
+// staticMethods are the methods a static file server answers.
+const staticMethods = "GET, HEAD, OPTIONS"
+
+// allowMethods answers methods other than GET and HEAD with 405, since
+// FileServer would otherwise serve a file to a POST or DELETE as if it were
+// a GET. OPTIONS gets the Allow list. Paths under exempt, like -proxy
+// backends and POST /shutdown, take every method.
+func allowMethods(exempt []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, prefix := range exempt {
+			if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", staticMethods)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	})
+}
+
+// clientIP extracts the client address from r.RemoteAddr, dropping the port
+// and any IPv6 zone.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	host, _, _ = strings.Cut(host, "%")
+	return net.ParseIP(host)
+}
+
+// allowlist returns 403 to clients outside the given networks. Loopback is
+// always allowed so the local browser keeps working.
+func allowlist(nets []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if ip == nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if ip.IsLoopback() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, n := range nets {
+			if n.Contains(ip) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
+
+// activeRequests is the number of requests currently being served, and
+// peakRequests the most there have been at once.
+var activeRequests, peakRequests atomic.Int64
+
+// countActive tracks in-flight requests in activeRequests. Event streams
+// stay open as long as a tab does, so they aren't counted.
+func countActive(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == "text/event-stream" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		n := activeRequests.Add(1)
+		defer activeRequests.Add(-1)
+		for {
+			peak := peakRequests.Load()
+			if n <= peak || peakRequests.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serverTiming adds a Server-Timing header, which browser dev tools show
+// in the Network tab, with how long the server took to start answering:
+// finding the file, or the proxy's wait for its backend. Headers go out
+// before the body, so writing the body isn't included.
+func serverTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&timingWriter{ResponseWriter: w, start: time.Now()}, r)
+	})
+}
+
+// timingWriter sets Server-Timing as the header is written.
+type timingWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	wroteHeader bool
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		ms := float64(time.Since(w.start).Microseconds()) / 1000
+		w.Header().Add("Server-Timing", fmt.Sprintf("app;dur=%.1f", ms))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// busyRequests counts requests -max-conns turned away.
+var busyRequests atomic.Int64
+
+// queueWait is how long a request over -max-conns waits for a slot.
+const queueWait = 5 * time.Second
+
+// limitConcurrent lets at most max requests run at once. Others wait up to
+// queueWait for a slot, then get 503 with Retry-After, so a crowd slows the
+// app down instead of overwhelming the machine. Like countActive, it leaves
+// event streams and WebSockets alone, since they'd hold a slot for good.
+func limitConcurrent(max int, next http.Handler) http.Handler {
+	slots := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == "text/event-stream" || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			timer := time.NewTimer(queueWait)
+			defer timer.Stop()
+			select {
+			case slots <- struct{}{}:
+			case <-timer.C:
+				busyRequests.Add(1)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Server busy", http.StatusServiceUnavailable)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		defer func() { <-slots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveInfo answers -info's /__info with how the app is being served, so
+// a page or script can find its LAN address without guessing.
+func serveInfo(w http.ResponseWriter, r *http.Request) {
+	type urls struct {
+		Local   string `json:"local,omitempty"`
+		LAN     string `json:"lan,omitempty"`
+		LANIPv6 string `json:"lanIPv6,omitempty"`
+		HTTP    string `json:"http,omitempty"`
+		MDNS    string `json:"mdns,omitempty"`
+		Public  string `json:"public,omitempty"`
+	}
+	v4, v6 := currentLANIPs()
+	url, url6 := currentLANURLs()
+	u := urls{Local: localURL, LAN: url, HTTP: currentHTTPURL(), Public: cfg.PublicURL}
+	if url6 != url {
+		u.LANIPv6 = url6
+	}
+	if mdnsServer != nil {
+		u.MDNS = appURL(hostURL(urlScheme, cfg.MDNSName+".local", urlPort))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		LANIP   string `json:"lanIP"`
+		LANIPv6 string `json:"lanIPv6,omitempty"`
+		Port    int    `json:"port"`
+		URLs    urls   `json:"urls"`
+		OS      string `json:"os"`
+	}{v4, v6, urlPort, u, runtime.GOOS})
+}
+
+// paused makes the server answer every request with 503 until resumed.
+var paused atomic.Bool
+
+func pauseGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if paused.Load() {
+			http.Error(w, "Server paused", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// healthz answers readiness probes so scripts can wait for the server. It
+// reports the port the probe arrived on, since HTTP and HTTPS may differ.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	var port int
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok {
+		port = addr.Port
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		Status string `json:"status"`
+		Port   int    `json:"port"`
+	}{"ok", port})
+}
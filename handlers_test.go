@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowMethods(t *testing.T) {
+	files := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("file"))
+	})
+	h := allowMethods([]string{"/api"}, files)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/index.html", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /index.html: status = %d, want 405", rec.Code)
+	}
+	if got := rec.Header().Get("Allow"); got != staticMethods {
+		t.Errorf("POST /index.html: Allow = %q, want %q", got, staticMethods)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("OPTIONS", "/index.html", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") != staticMethods {
+		t.Errorf("OPTIONS: got %d with Allow %q, want 204 with the list", rec.Code, rec.Header().Get("Allow"))
+	}
+
+	for _, path := range []string{"/api", "/api/users"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("POST %s: status = %d, want it exempt", path, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/apiary", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /apiary: status = %d, want 405 outside the exempt prefix", rec.Code)
+	}
+}
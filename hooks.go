@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// connectHooks caps how often -on-connect runs, so a burst of devices, or
+// one hopping between addresses, can't start a pile of processes.
+var connectHooks = rate.NewLimiter(rate.Every(time.Second), 5)
+
+// runConnectHook runs the -on-connect command for a newly seen device,
+// with its IP as the last argument and in DAPPTOON_CLIENT_IP, and logs what
+// it prints. The command is split on spaces, not run through a shell; a
+// script can do anything fancier.
+func runConnectHook(command, ip string) {
+	if !connectHooks.Allow() {
+		log.Printf("Skipped -on-connect for %s: too many devices at once", ip)
+		return
+	}
+	args := strings.Fields(command)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], ip)...)
+	cmd.Env = append(os.Environ(), "DAPPTOON_CLIENT_IP="+ip)
+	out, err := cmd.CombinedOutput()
+	if text := strings.TrimSpace(string(out)); text != "" {
+		log.Printf("-on-connect %s: %s", ip, text)
+	}
+	if err != nil {
+		log.Printf("-on-connect for %s failed: %v", ip, err)
+	}
+}
+
+// companion is the -exec process while it runs.
+var companion struct {
+	sync.Mutex
+	cmd      *exec.Cmd
+	done     chan struct{}
+	stopping bool
+}
+
+// startCompanion runs the -exec command alongside the server, such as a
+// small backend, with each line it prints going to the log. Like
+// -on-connect, the command is split on spaces rather than run by a shell.
+func startCompanion(command string) {
+	args := strings.Fields(command)
+	name := filepath.Base(args[0])
+	cmd := exec.Command(args[0], args[1:]...)
+	r, w := io.Pipe()
+	cmd.Stdout, cmd.Stderr = w, w
+	// Don't wait on output from anything it left running in the background
+	cmd.WaitDelay = time.Second
+	done := make(chan struct{})
+	companion.Lock()
+	// Quitting before the server came up
+	if companion.stopping {
+		companion.Unlock()
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		companion.Unlock()
+		warn("Failed to start -exec: " + err.Error())
+		return
+	}
+	companion.cmd, companion.done = cmd, done
+	companion.Unlock()
+	log.Printf("Started %s (pid %d)", command, cmd.Process.Pid)
+	logged := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			log.Printf("[%s] %s", name, scanner.Text())
+		}
+		// Drain whatever's left so the process never blocks writing
+		io.Copy(io.Discard, r)
+		close(logged)
+	}()
+	go func() {
+		err := cmd.Wait()
+		w.Close()
+		<-logged
+		companion.Lock()
+		stopping := companion.stopping
+		companion.cmd = nil
+		companion.Unlock()
+		close(done)
+		if stopping {
+			return
+		}
+		msg := name + " exited"
+		if err != nil {
+			msg = name + " exited: " + err.Error()
+		}
+		logEvent("exec_exit", msg, "command", command)
+		notify(msg)
+	}()
+}
+
+// stopCompanion ends the -exec process on quit, asking it to stop first
+// and killing it if it hasn't after a few seconds. Windows has no such
+// request to send, so there it's killed outright.
+func stopCompanion() {
+	companion.Lock()
+	cmd, done := companion.cmd, companion.done
+	companion.stopping = true
+	companion.Unlock()
+	if cmd == nil {
+		return
+	}
+	if runtime.GOOS == "windows" || cmd.Process.Signal(os.Interrupt) != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+}
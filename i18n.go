@@ -0,0 +1,278 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// translations maps a language to its tray text, keyed by the English
+// text. Anything missing falls back to English.
+var translations = map[string]map[string]string{
+	"de": {
+		"React Server":                  "React-Server",
+		"Serving your React app":        "Stellt deine React-App bereit",
+		"Served Folder":                 "Bereitgestellter Ordner",
+		"Switch which folder is served": "Wählen, welcher Ordner bereitgestellt wird",
+		"LAN IP":                        "LAN-IP",
+		"Choose the address other devices are given": "Adresse wählen, die andere Geräte bekommen",
+		"Automatic": "Automatisch",
+		"Pick the address most likely to be reachable": "Die am ehesten erreichbare Adresse wählen",
+		"No LAN IP (local only)":                       "Keine LAN-IP (nur lokal)",
+		"The app's LAN address":                        "LAN-Adresse der App",
+		"Build: %s":                                    "Build: %s",
+		"Version of the embedded front-end build":      "Version des eingebetteten Frontend-Builds",
+		"Open App":               "App öffnen",
+		"Open in browser":        "Im Browser öffnen",
+		"Copy LAN URL":           "LAN-URL kopieren",
+		"Copy link to clipboard": "Link in die Zwischenablage kopieren",
+		"Copy as Markdown Link":  "Als Markdown-Link kopieren",
+		"Copy the LAN link formatted for docs and chat":               "LAN-Link für Dokus und Chats formatiert kopieren",
+		"Copy Device Command":                                         "Gerätebefehl kopieren",
+		"Copy a command that opens the LAN link on a connected phone": "Befehl kopieren, der den LAN-Link auf einem verbundenen Handy öffnet",
+		"Copy Local URL":                                              "Lokale URL kopieren",
+		"Copy localhost link to clipboard":                            "localhost-Link in die Zwischenablage kopieren",
+		"Copy All URLs":                                               "Alle URLs kopieren",
+		"Copy every address the app is at, one per line":              "Jede Adresse der App kopieren, eine pro Zeile",
+		"Copy IPv6 URL":                                               "IPv6-URL kopieren",
+		"Copy IPv6 link to clipboard":                                 "IPv6-Link in die Zwischenablage kopieren",
+		"Copy HTTP URL":                                               "HTTP-URL kopieren",
+		"Copy plain-HTTP link to clipboard":                           "Unverschlüsselten HTTP-Link kopieren",
+		"Open Folder":                                                 "Ordner öffnen",
+		"Show the served folder in the file manager":                  "Bereitgestellten Ordner im Dateimanager zeigen",
+		"Show QR Code":                                                "QR-Code anzeigen",
+		"Open QR code for phone":                                      "QR-Code fürs Handy öffnen",
+		"Copy QR Image":                                               "QR-Bild kopieren",
+		"Copy the QR code image to the clipboard":                     "QR-Code-Bild in die Zwischenablage kopieren",
+		"Save QR Code…":                                               "QR-Code speichern…",
+		"Save the QR code as an image file":                           "QR-Code als Bilddatei speichern",
+		"Regenerate QR Now":                                           "QR-Code jetzt neu erzeugen",
+		"Detect the LAN IP again and show a fresh QR code":            "LAN-IP neu ermitteln und einen frischen QR-Code zeigen",
+		"Pause Serving":                                               "Bereitstellung pausieren",
+		"Resume Serving":                                              "Bereitstellung fortsetzen",
+		"Temporarily answer all requests with 503":                    "Alle Anfragen vorübergehend mit 503 beantworten",
+		"Restart Server":                                              "Server neu starten",
+		"Restart the HTTP server":                                     "HTTP-Server neu starten",
+		"Verbose Logging":                                             "Ausführliches Protokoll",
+		"Log every request":                                           "Jede Anfrage protokollieren",
+		"LAN Access":                                                  "LAN-Zugriff",
+		"Let other devices on the network connect":                    "Andere Geräte im Netzwerk verbinden lassen",
+		"LAN access off (local only)":                                 "LAN-Zugriff aus (nur lokal)",
+		"Stats":                                                       "Statistik",
+		"Uptime and requests served":                                  "Laufzeit und bediente Anfragen",
+		"Diagnostics":                                                 "Diagnose",
+		"Recent warnings and errors":                                  "Letzte Warnungen und Fehler",
+		"No problems so far":                                          "Bisher keine Probleme",
+		"Use Live Folder":                                             "Live-Ordner verwenden",
+		"Serve the -dir folder rather than the build inside the app": "Den -dir-Ordner statt des eingebauten Builds ausliefern",
+		"Server: not running":               "Server: läuft nicht",
+		"Server: listening on port %d":      "Server: lauscht auf Port %d",
+		"LAN IP: %s":                        "LAN-IP: %s",
+		"none":                              "keine",
+		"Uptime: %s":                        "Laufzeit: %s",
+		"Requests: %d":                      "Anfragen: %d",
+		"Peak concurrent: %d":               "Höchstens gleichzeitig: %d",
+		"Active: %d of %d (%d turned away)": "Aktiv: %d von %d (%d abgewiesen)",
+		"Top devices:":                      "Geräte mit den meisten Daten:",
+		"Clients that downloaded the most":  "Clients, die am meisten heruntergeladen haben",
+		"Copy Server Info":                  "Serverinfo kopieren",
+		"Copy diagnostics for a bug report": "Diagnosedaten für einen Fehlerbericht kopieren",
+		"Quit":                              "Beenden",
+		"Stop the server":                   "Server stoppen",
+		"Serving at %s":                     "Bereitgestellt unter %s",
+		"Serving at %s (no LAN IP)":         "Bereitgestellt unter %s (keine LAN-IP)",
+		" (firewall may block LAN access)":  " (Firewall blockiert evtl. den LAN-Zugriff)",
+		" — 1 active request":               " — 1 aktive Anfrage",
+		" — %d active requests":             " — %d aktive Anfragen",
+	},
+	"es": {
+		"React Server":                  "Servidor React",
+		"Serving your React app":        "Sirviendo tu app React",
+		"Served Folder":                 "Carpeta servida",
+		"Switch which folder is served": "Cambiar la carpeta que se sirve",
+		"LAN IP":                        "IP de LAN",
+		"Choose the address other devices are given": "Elegir la dirección que reciben otros dispositivos",
+		"Automatic": "Automática",
+		"Pick the address most likely to be reachable": "Elegir la dirección con más probabilidades de ser accesible",
+		"No LAN IP (local only)":                       "Sin IP de LAN (solo local)",
+		"The app's LAN address":                        "Dirección LAN de la app",
+		"Build: %s":                                    "Compilación: %s",
+		"Version of the embedded front-end build":      "Versión de la compilación del front-end incluida",
+		"Open App":               "Abrir app",
+		"Open in browser":        "Abrir en el navegador",
+		"Copy LAN URL":           "Copiar URL de LAN",
+		"Copy link to clipboard": "Copiar el enlace al portapapeles",
+		"Copy as Markdown Link":  "Copiar como enlace Markdown",
+		"Copy the LAN link formatted for docs and chat":               "Copiar el enlace de LAN con formato para documentos y chats",
+		"Copy Device Command":                                         "Copiar comando del dispositivo",
+		"Copy a command that opens the LAN link on a connected phone": "Copiar un comando que abre el enlace LAN en un teléfono conectado",
+		"Copy Local URL":                                              "Copiar URL local",
+		"Copy localhost link to clipboard":                            "Copiar el enlace de localhost al portapapeles",
+		"Copy All URLs":                                               "Copiar todas las URL",
+		"Copy every address the app is at, one per line":              "Copiar todas las direcciones de la app, una por línea",
+		"Copy IPv6 URL":                                               "Copiar URL IPv6",
+		"Copy IPv6 link to clipboard":                                 "Copiar el enlace IPv6 al portapapeles",
+		"Copy HTTP URL":                                               "Copiar URL HTTP",
+		"Copy plain-HTTP link to clipboard":                           "Copiar el enlace HTTP sin cifrar",
+		"Open Folder":                                                 "Abrir carpeta",
+		"Show the served folder in the file manager":                  "Mostrar la carpeta servida en el explorador de archivos",
+		"Show QR Code":                                                "Mostrar código QR",
+		"Open QR code for phone":                                      "Abrir el código QR para el móvil",
+		"Copy QR Image":                                               "Copiar imagen QR",
+		"Copy the QR code image to the clipboard":                     "Copiar la imagen del código QR al portapapeles",
+		"Save QR Code…":                                               "Guardar código QR…",
+		"Save the QR code as an image file":                           "Guardar el código QR como imagen",
+		"Regenerate QR Now":                                           "Regenerar QR ahora",
+		"Detect the LAN IP again and show a fresh QR code":            "Detectar de nuevo la IP de LAN y mostrar un código QR nuevo",
+		"Pause Serving":                                               "Pausar",
+		"Resume Serving":                                              "Reanudar",
+		"Temporarily answer all requests with 503":                    "Responder temporalmente a todas las solicitudes con 503",
+		"Restart Server":                                              "Reiniciar servidor",
+		"Restart the HTTP server":                                     "Reiniciar el servidor HTTP",
+		"Verbose Logging":                                             "Registro detallado",
+		"Log every request":                                           "Registrar cada solicitud",
+		"LAN Access":                                                  "Acceso LAN",
+		"Let other devices on the network connect":                    "Permitir que otros dispositivos de la red se conecten",
+		"LAN access off (local only)":                                 "Acceso LAN desactivado (solo local)",
+		"Stats":                                                       "Estadísticas",
+		"Uptime and requests served":                                  "Tiempo activo y solicitudes servidas",
+		"Diagnostics":                                                 "Diagnóstico",
+		"Recent warnings and errors":                                  "Advertencias y errores recientes",
+		"No problems so far":                                          "Sin problemas por ahora",
+		"Use Live Folder":                                             "Usar carpeta en vivo",
+		"Serve the -dir folder rather than the build inside the app": "Servir la carpeta de -dir en lugar de la compilación incluida en la app",
+		"Server: not running":               "Servidor: detenido",
+		"Server: listening on port %d":      "Servidor: escuchando en el puerto %d",
+		"LAN IP: %s":                        "IP de LAN: %s",
+		"none":                              "ninguna",
+		"Uptime: %s":                        "Tiempo activo: %s",
+		"Requests: %d":                      "Solicitudes: %d",
+		"Peak concurrent: %d":               "Pico simultáneo: %d",
+		"Active: %d of %d (%d turned away)": "Activas: %d de %d (%d rechazadas)",
+		"Top devices:":                      "Dispositivos con más tráfico:",
+		"Clients that downloaded the most":  "Clientes que más han descargado",
+		"Copy Server Info":                  "Copiar info del servidor",
+		"Copy diagnostics for a bug report": "Copiar diagnósticos para un informe de error",
+		"Quit":                              "Salir",
+		"Stop the server":                   "Detener el servidor",
+		"Serving at %s":                     "Sirviendo en %s",
+		"Serving at %s (no LAN IP)":         "Sirviendo en %s (sin IP de LAN)",
+		" (firewall may block LAN access)":  " (el cortafuegos puede bloquear el acceso LAN)",
+		" — 1 active request":               " — 1 solicitud activa",
+		" — %d active requests":             " — %d solicitudes activas",
+	},
+	"fr": {
+		"React Server":                  "Serveur React",
+		"Serving your React app":        "Sert votre app React",
+		"Served Folder":                 "Dossier servi",
+		"Switch which folder is served": "Changer le dossier servi",
+		"LAN IP":                        "IP LAN",
+		"Choose the address other devices are given": "Choisir l'adresse donnée aux autres appareils",
+		"Automatic": "Automatique",
+		"Pick the address most likely to be reachable": "Choisir l'adresse la plus susceptible d'être joignable",
+		"No LAN IP (local only)":                       "Pas d'IP LAN (local uniquement)",
+		"The app's LAN address":                        "Adresse LAN de l'app",
+		"Build: %s":                                    "Build : %s",
+		"Version of the embedded front-end build":      "Version du build front-end intégré",
+		"Open App":               "Ouvrir l'app",
+		"Open in browser":        "Ouvrir dans le navigateur",
+		"Copy LAN URL":           "Copier l'URL LAN",
+		"Copy link to clipboard": "Copier le lien dans le presse-papiers",
+		"Copy as Markdown Link":  "Copier en lien Markdown",
+		"Copy the LAN link formatted for docs and chat":               "Copier le lien LAN mis en forme pour la doc et les discussions",
+		"Copy Device Command":                                         "Copier la commande appareil",
+		"Copy a command that opens the LAN link on a connected phone": "Copier une commande qui ouvre le lien LAN sur un téléphone connecté",
+		"Copy Local URL":                                              "Copier l'URL locale",
+		"Copy localhost link to clipboard":                            "Copier le lien localhost dans le presse-papiers",
+		"Copy All URLs":                                               "Copier toutes les URL",
+		"Copy every address the app is at, one per line":              "Copier toutes les adresses de l'app, une par ligne",
+		"Copy IPv6 URL":                                               "Copier l'URL IPv6",
+		"Copy IPv6 link to clipboard":                                 "Copier le lien IPv6 dans le presse-papiers",
+		"Copy HTTP URL":                                               "Copier l'URL HTTP",
+		"Copy plain-HTTP link to clipboard":                           "Copier le lien HTTP non chiffré",
+		"Open Folder":                                                 "Ouvrir le dossier",
+		"Show the served folder in the file manager":                  "Afficher le dossier servi dans le gestionnaire de fichiers",
+		"Show QR Code":                                                "Afficher le QR code",
+		"Open QR code for phone":                                      "Ouvrir le QR code pour le téléphone",
+		"Copy QR Image":                                               "Copier l'image QR",
+		"Copy the QR code image to the clipboard":                     "Copier l'image du QR code dans le presse-papiers",
+		"Save QR Code…":                                               "Enregistrer le QR code…",
+		"Save the QR code as an image file":                           "Enregistrer le QR code comme image",
+		"Regenerate QR Now":                                           "Régénérer le QR code",
+		"Detect the LAN IP again and show a fresh QR code":            "Détecter à nouveau l'IP LAN et afficher un nouveau QR code",
+		"Pause Serving":                                               "Mettre en pause",
+		"Resume Serving":                                              "Reprendre",
+		"Temporarily answer all requests with 503":                    "Répondre temporairement 503 à toutes les requêtes",
+		"Restart Server":                                              "Redémarrer le serveur",
+		"Restart the HTTP server":                                     "Redémarrer le serveur HTTP",
+		"Verbose Logging":                                             "Journal détaillé",
+		"Log every request":                                           "Journaliser chaque requête",
+		"LAN Access":                                                  "Accès LAN",
+		"Let other devices on the network connect":                    "Autoriser les autres appareils du réseau à se connecter",
+		"LAN access off (local only)":                                 "Accès LAN désactivé (local uniquement)",
+		"Stats":                                                       "Statistiques",
+		"Uptime and requests served":                                  "Durée de fonctionnement et requêtes servies",
+		"Diagnostics":                                                 "Diagnostic",
+		"Recent warnings and errors":                                  "Avertissements et erreurs récents",
+		"No problems so far":                                          "Aucun problème pour l'instant",
+		"Use Live Folder":                                             "Utiliser le dossier en direct",
+		"Serve the -dir folder rather than the build inside the app": "Servir le dossier -dir plutôt que le build intégré à l'app",
+		"Server: not running":               "Serveur : arrêté",
+		"Server: listening on port %d":      "Serveur : à l'écoute sur le port %d",
+		"LAN IP: %s":                        "IP LAN : %s",
+		"none":                              "aucune",
+		"Uptime: %s":                        "Durée : %s",
+		"Requests: %d":                      "Requêtes : %d",
+		"Peak concurrent: %d":               "Pic simultané : %d",
+		"Active: %d of %d (%d turned away)": "Actives : %d sur %d (%d refusées)",
+		"Top devices:":                      "Principaux appareils :",
+		"Clients that downloaded the most":  "Clients ayant le plus téléchargé",
+		"Copy Server Info":                  "Copier les infos serveur",
+		"Copy diagnostics for a bug report": "Copier les diagnostics pour un rapport de bug",
+		"Quit":                              "Quitter",
+		"Stop the server":                   "Arrêter le serveur",
+		"Serving at %s":                     "Servi sur %s",
+		"Serving at %s (no LAN IP)":         "Servi sur %s (pas d'IP LAN)",
+		" (firewall may block LAN access)":  " (le pare-feu bloque peut-être l'accès LAN)",
+		" — 1 active request":               " — 1 requête active",
+		" — %d active requests":             " — %d requêtes actives",
+	},
+}
+
+// lang is the language the tray is shown in.
+var lang = "en"
+
+// setLang picks the tray language from -lang, or the OS locale when it's
+// empty, falling back to English for languages without translations.
+func setLang(flagLang string) {
+	l := flagLang
+	if l == "" {
+		l = osLocale()
+	}
+	// "de_DE.UTF-8", "de-DE", and "de" all mean German
+	l = strings.ToLower(l)
+	if i := strings.IndexAny(l, "_-."); i >= 0 {
+		l = l[:i]
+	}
+	if _, ok := translations[l]; ok {
+		lang = l
+	}
+}
+
+// tr returns the tray text for msg, the English text, in lang.
+func tr(msg string) string {
+	if t, ok := translations[lang][msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// envLocale reads the locale from the POSIX environment variables, in
+// order of precedence.
+func envLocale() string {
+	for _, v := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if l := os.Getenv(v); l != "" && l != "C" && l != "POSIX" {
+			return l
+		}
+	}
+	return ""
+}
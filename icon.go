@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+)
+
+// loadIcon reads a PNG from path to replace the embedded icon with.
+func loadIcon(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := png.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("%s isn't a PNG: %w", path, err)
+	}
+	return data, nil
+}
+
+// dimIcon returns a copy of a PNG icon at reduced opacity, falling back to
+// the original when it can't be decoded.
+func dimIcon(data []byte) []byte {
+	src, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return data
+	}
+
+	b := src.Bounds()
+	dst := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+			c.A = uint8(uint16(c.A) * 2 / 5)
+			dst.SetNRGBA(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// badgeIcon returns a copy of a PNG icon with a status dot in the bottom
+// right corner, ringed in white so it reads on light and dark menu bars.
+// It falls back to the original when the icon can't be decoded.
+func badgeIcon(data []byte, dot color.NRGBA) []byte {
+	src, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return data
+	}
+
+	b := src.Bounds()
+	dst := image.NewNRGBA(b)
+	draw.Draw(dst, b, src, b.Min, draw.Src)
+
+	r := float64(b.Dx()) / 5
+	ring := r + math.Max(1, r/4)
+	cx, cy := float64(b.Max.X)-ring, float64(b.Max.Y)-ring
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			switch {
+			case d <= r:
+				dst.SetNRGBA(x, y, dot)
+			case d <= ring:
+				dst.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
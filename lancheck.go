@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// carrierNAT is the shared address space carriers and phone hotspots hand
+// out, where clients are often isolated from each other.
+var carrierNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// unusualLANIP explains why other devices may not reach ip, or returns ""
+// for an ordinary private LAN address.
+func unusualLANIP(ip net.IP) string {
+	switch {
+	case carrierNAT.Contains(ip):
+		return "is in a carrier-grade NAT range, as on mobile hotspots that often keep devices apart"
+	case dockerBridge.Contains(ip):
+		return "belongs to Docker's bridge network"
+	case !ip.IsPrivate():
+		return "isn't a private LAN address, so this may be a public or captive-portal network"
+	}
+	return ""
+}
+
+// checkLAN warns, without stopping anything, when LAN sharing looks unlikely
+// to work: the LAN IP is in an unusual range, or the server can't reach
+// itself through it. It can't see AP isolation on its own; the range check
+// covers the networks where that's common.
+func checkLAN() {
+	if localOnly() || cfg.PublicURL != "" {
+		return
+	}
+	url, _ := currentLANURLs()
+	v4, _ := currentLANIPs()
+	ip := net.ParseIP(v4)
+	if url == "" || ip == nil {
+		return
+	}
+
+	var problems []string
+	if why := unusualLANIP(ip); why != "" {
+		problems = append(problems, v4+" "+why)
+	}
+	if err := waitUntilServing(strings.TrimSuffix(url, "/")+"/healthz", 2*time.Second); err != nil {
+		problems = append(problems, "this computer can't reach its own LAN URL "+url+" ("+err.Error()+")")
+	}
+	if len(problems) == 0 {
+		return
+	}
+	msg := "LAN sharing may not work: " + strings.Join(problems, "; ")
+	warn(msg)
+	notify(msg)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseLangIndex parses "lang=file", as given to -index-lang.
+func parseLangIndex(v string) (lang, file string, err error) {
+	lang, file, ok := strings.Cut(v, "=")
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if !ok || lang == "" || file == "" || strings.ContainsAny(file, `/\`) {
+		return "", "", fmt.Errorf(`expected "lang=file" like fr=index.fr.html`)
+	}
+	return lang, file, nil
+}
+
+// langIndex serves the root page in the visitor's language, picking from
+// indexes by Accept-Language, and the default index when none match or the
+// match is the default index itself.
+func langIndex(root http.FileSystem, indexes map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		for _, lang := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+			file, ok := indexes[lang]
+			if !ok {
+				// fr-CA is still French
+				primary, _, _ := strings.Cut(lang, "-")
+				file, ok = indexes[primary]
+			}
+			if !ok {
+				continue
+			}
+			// Asking for it by name would just be redirected back to /
+			if file == cfg.Index {
+				break
+			}
+			if f, err := root.Open("/" + file); err == nil {
+				f.Close()
+				r2 := r.Clone(r.Context())
+				r2.URL.Path = "/" + file
+				next.ServeHTTP(w, r2)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptedLanguages lists the tags in an Accept-Language header, lowercased
+// and most preferred first, leaving out any refused with q=0.
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			langs = append(langs, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mstenq/dapptoon/server"
+)
+
+// listingPage renders a folder's contents for -listing.
+var listingPage = template.Must(template.New("listing").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Path}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 56em; margin: 2em auto; padding: 0 1em; color: #222; }
+h1 { font-size: 1.25em; word-break: break-all; }
+table { width: 100%; border-collapse: collapse; }
+th, td { text-align: left; padding: 0.4em 0.6em; border-bottom: 1px solid #eee; }
+th { color: #666; font-weight: normal; }
+td.size, th.size { text-align: right; white-space: nowrap; }
+td.mod { color: #666; white-space: nowrap; }
+a { color: #0366d6; text-decoration: none; }
+a:hover { text-decoration: underline; }
+</style>
+</head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+<tr><th>Name</th><th class="size">Size</th><th>Modified</th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td class="size">{{.Size}}</td><td class="mod">{{.Modified}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type listingEntry struct {
+	Name, Href, Size, Modified string
+}
+
+// dirListing serves a listing for folders without an index.html, and the
+// 404 page for missing paths, in place of the SPA fallback. Everything else
+// goes to next.
+func dirListing(root http.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name := path.Clean(r.URL.Path)
+		f, err := root.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			server.ServeNotFound(root, w, r)
+			return
+		}
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+
+		// FileServer handles files, index pages, and the trailing-slash redirect
+		info, err := f.Stat()
+		if err != nil || !info.IsDir() || !strings.HasSuffix(r.URL.Path, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if index, err := root.Open(path.Join(name, cfg.Index)); err == nil {
+			index.Close()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			http.Error(w, "Failed to read folder", http.StatusInternalServerError)
+			return
+		}
+		// Folders first, then by name
+		sort.Slice(infos, func(i, j int) bool {
+			if infos[i].IsDir() != infos[j].IsDir() {
+				return infos[i].IsDir()
+			}
+			return strings.ToLower(infos[i].Name()) < strings.ToLower(infos[j].Name())
+		})
+
+		entries := make([]listingEntry, 0, len(infos))
+		for _, fi := range infos {
+			e := listingEntry{
+				Name:     fi.Name(),
+				Href:     url.PathEscape(fi.Name()),
+				Modified: fi.ModTime().Format(time.DateTime),
+			}
+			if fi.IsDir() {
+				e.Name += "/"
+				e.Href += "/"
+			} else {
+				e.Size = formatSize(fi.Size())
+			}
+			entries = append(entries, e)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Method == http.MethodHead {
+			return
+		}
+		listingPage.Execute(w, struct {
+			Path    string
+			Entries []listingEntry
+		}{name, entries})
+	})
+}
+
+// formatSize renders a byte count like 1.5 MB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
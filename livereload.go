@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadHub fans a reload event out to every connected browser.
+type reloadHub struct {
+	mu sync.Mutex
+	// clients maps each stream to whether it's from this computer
+	clients map[chan struct{}]bool
+}
+
+func newReloadHub() *reloadHub {
+	return &reloadHub{clients: make(map[chan struct{}]bool)}
+}
+
+var (
+	// reloads fires when a watched folder changes
+	reloads = newReloadHub()
+	// ipChanges fires when the LAN IP changes, so open QR pages refresh
+	ipChanges = newReloadHub()
+)
+
+func (h *reloadHub) subscribe(local bool) chan struct{} {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	h.clients[ch] = local
+	h.mu.Unlock()
+	return ch
+}
+
+// hasLocal reports whether a page on this computer is connected, i.e. the
+// app is open in a tab here.
+func (h *reloadHub) hasLocal() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, local := range h.clients {
+		if local {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *reloadHub) unsubscribe(ch chan struct{}) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+}
+
+// disconnectAll ends every stream; browsers reconnect on their own once a
+// server is back.
+func (h *reloadHub) disconnectAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		close(ch)
+		delete(h.clients, ch)
+	}
+}
+
+func (h *reloadHub) broadcast() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		// A client that already has a reload pending doesn't need another
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// serveEvents streams a "reload" server-sent event on every broadcast.
+func (h *reloadHub) serveEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	rc := http.NewResponseController(w)
+	// The stream stays open as long as the tab, well past -write-timeout
+	rc.SetWriteDeadline(time.Time{})
+
+	ch := h.subscribe(clientIP(r).IsLoopback())
+	defer h.unsubscribe(ch)
+
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+			w.Write([]byte("data: reload\n\n"))
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// watchDirs watches the folders recursively and calls onChange once a burst
+// of file events has settled for settle, so a rebuild triggers it once.
+func watchDirs(dirs []string, settle time.Duration, onChange func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := addTree(w, dir); err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	go func() {
+		var debounce *time.Timer
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				// fsnotify isn't recursive, so pick up new subfolders
+				if ev.Has(fsnotify.Create) {
+					addTree(w, ev.Name)
+				}
+				if debounce != nil {
+					debounce.Stop()
+				}
+				debounce = time.AfterFunc(settle, onChange)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Println("File watcher:", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// addTree adds root and every folder below it to the watcher.
+func addTree(w *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		return w.Add(path)
+	})
+}
+
+// reloadScript reconnects to /livereload and reloads the page on each event,
+// and when it gets back to the server after losing it, e.g. across a
+// -watch-restart.
+const reloadScript = `<script>{const es = new EventSource(%q); let lost = false; es.onerror = () => lost = true; es.onopen = () => lost && location.reload(); es.onmessage = () => location.reload();}</script>`
+
+// injectWriter buffers an HTML response so the live-reload script can be
+// added before it is sent.
+type injectWriter struct {
+	http.ResponseWriter
+	buf    bytes.Buffer
+	status int
+	inject bool
+}
+
+func (w *injectWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	// A precompressed page can't be edited without decoding it
+	w.inject = code == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") && w.Header().Get("Content-Encoding") == ""
+	if !w.inject {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *injectWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.inject {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// finish sends the buffered page as edit changes it.
+func (w *injectWriter) finish(edit func(page []byte) []byte) {
+	if !w.inject {
+		return
+	}
+	page := edit(w.buf.Bytes())
+	w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(page)
+}
+
+// identityPage has a page navigation asked for without Accept-Encoding, so
+// that -precompressed serves the plain file a script can be added to; the
+// result is still compressed on the way out.
+func identityPage(r *http.Request) *http.Request {
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") || r.Header.Get("Accept-Encoding") == "" {
+		return r
+	}
+	r = r.Clone(r.Context())
+	r.Header.Del("Accept-Encoding")
+	return r
+}
+
+// insertBefore inserts s before the last tag in page, or appends it when
+// there's no such tag.
+func insertBefore(page []byte, tag, s string) []byte {
+	if i := bytes.LastIndex(page, []byte(tag)); i >= 0 {
+		return append(page[:i:i], append([]byte(s), page[i:]...)...)
+	}
+	return append(page, s...)
+}
+
+// injectReload adds the live-reload script to HTML pages.
+func injectReload(endpoint string, next http.Handler) http.Handler {
+	script := fmt.Sprintf(reloadScript, endpoint)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		iw := &injectWriter{ResponseWriter: w}
+		next.ServeHTTP(iw, identityPage(r))
+		iw.finish(func(page []byte) []byte {
+			return insertBefore(page, "</body>", script)
+		})
+	})
+}
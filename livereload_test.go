@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testConfig sets cfg for the length of a test, starting from the flag
+// defaults the handlers rely on.
+func testConfig(t *testing.T, edit func(c *config)) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg = config{Index: "index.html"}
+	if edit != nil {
+		edit(&cfg)
+	}
+}
+
+// testDir writes files, keyed by slash-separated name, to a temporary
+// folder and returns it.
+func testDir(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestInjectSkipsPrecompressedPages(t *testing.T) {
+	testConfig(t, func(c *config) { c.Precompressed = true })
+	dir := testDir(t, map[string]string{
+		"about.html":    "<html><body>About</body></html>",
+		"about.html.br": "brotli bytes",
+	})
+	h := injectReload("/livereload", newSite("test", dir, http.Dir(dir)).handler)
+
+	// A fetch gets the sibling as it is
+	req := httptest.NewRequest("GET", "/about.html", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Encoding"); got != "br" {
+		t.Errorf("fetch: Content-Encoding = %q, want br", got)
+	}
+	if got := rec.Body.String(); got != "brotli bytes" {
+		t.Errorf("fetch: body = %q, want the sibling unchanged", got)
+	}
+
+	// A navigation gets the plain page, with the script
+	req = httptest.NewRequest("GET", "/about.html", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Encoding", "br")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("navigation: Content-Encoding = %q, want none", got)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "EventSource") || !strings.HasSuffix(body, "</body></html>") {
+		t.Errorf("navigation: body = %q, want the script before </body>", body)
+	}
+}
+
+func TestInjectConfigSkipsPrecompressedPages(t *testing.T) {
+	testConfig(t, func(c *config) { c.Precompressed = true })
+	dir := testDir(t, map[string]string{
+		"about.html":    "<html><head></head><body>About</body></html>",
+		"about.html.br": "brotli bytes",
+	})
+	settings := []runtimeSetting{{Key: "api", Value: "{origin}/api"}}
+	h := injectConfig(settings, newSite("test", dir, http.Dir(dir)).handler)
+
+	req := httptest.NewRequest("GET", "/about.html", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Body.String(); got != "brotli bytes" {
+		t.Errorf("body = %q, want the sibling unchanged", got)
+	}
+	if rec.Header().Get("ETag") == "" && rec.Header().Get("Last-Modified") == "" {
+		t.Error("validators were dropped from a page that wasn't edited")
+	}
+}
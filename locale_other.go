@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// osLocale returns the user's locale, e.g. "de_DE.UTF-8". Apps started from
+// the macOS Finder get no LANG, so there it falls back to the system
+// preference.
+func osLocale() string {
+	if l := envLocale(); l != "" {
+		return l
+	}
+	if runtime.GOOS == "darwin" {
+		if out, err := exec.Command("defaults", "read", "-g", "AppleLocale").Output(); err == nil {
+			return strings.TrimSpace(string(out))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// osLocale returns the user's Windows display locale, e.g. "de-DE".
+func osLocale() string {
+	if l := envLocale(); l != "" {
+		return l
+	}
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GetUserDefaultLocaleName")
+	if proc.Find() != nil {
+		return ""
+	}
+	buf := make([]uint16, 85) // LOCALE_NAME_MAX_LENGTH
+	if n, _, _ := proc.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf))); n == 0 {
+		return ""
+	}
+	return syscall.UTF16ToString(buf)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logOutput is where logs are written: stderr, or the -log-file.
+var logOutput io.Writer = os.Stderr
+
+// setLogFile sends logs to path, starting a new file once it reaches
+// maxSizeMB. Only the last few rotated files are kept, so an unattended
+// session can't fill the disk.
+func setLogFile(path string, maxSizeMB int) {
+	logOutput = &lumberjack.Logger{Filename: path, MaxSize: maxSizeMB, MaxBackups: 3}
+	log.SetOutput(logOutput)
+}
+
+// jsonLogs is set by -log-format json; log output then goes through slog's
+// JSON handler and requests and lifecycle events carry structured fields.
+var jsonLogs bool
+
+// clfLog writes -log-format clf access lines, without the log package's
+// timestamp prefix since each line carries its own.
+var clfLog *log.Logger
+
+// setLogFormat switches logging to format, "text", "json", or "clf".
+func setLogFormat(format string) error {
+	switch format {
+	case "text":
+	case "clf":
+		clfLog = log.New(logOutput, "", 0)
+	case "json":
+		jsonLogs = true
+		// Also routes the log package through the handler, so every
+		// existing log line comes out as a JSON record too
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logOutput, nil)))
+	default:
+		return fmt.Errorf("unknown log format %q: expected text, json, or clf", format)
+	}
+	return nil
+}
+
+// logEvent records a lifecycle event: as a record named name with attrs in
+// JSON mode, or as text in the default format (where an empty text means
+// the event isn't logged, e.g. because it's already printed to stdout).
+func logEvent(name, text string, attrs ...any) {
+	if jsonLogs {
+		slog.Info(name, attrs...)
+	} else if text != "" {
+		log.Println(text)
+	}
+}
+
+// responseWriter records the status code and body size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// verboseLogs turns the access log on; -verbose sets it at startup and the
+// tray's Verbose Logging item flips it while serving.
+var verboseLogs atomic.Bool
+
+// logRequests writes an access log line for each request:
+// METHOD PATH STATUS BYTES DURATION REMOTE_IP REQUEST_ID.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !verboseLogs.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if jsonLogs {
+			slog.Info("request",
+				"method", r.Method,
+				"path", r.URL.RequestURI(),
+				"status", status,
+				"bytes", rw.bytes,
+				"duration_ms", float64(time.Since(start).Microseconds())/1000,
+				"remote", clientIP(r).String(),
+				"request_id", r.Header.Get("X-Request-Id"),
+			)
+			return
+		}
+		if clfLog != nil {
+			clfLog.Println(combinedLogLine(r, status, rw.bytes, start))
+			return
+		}
+		log.Printf("%s %s %d %d %s %s %s", r.Method, r.URL.RequestURI(), status, rw.bytes, time.Since(start), clientIP(r), r.Header.Get("X-Request-Id"))
+	})
+}
+
+// combinedLogLine formats a request in Apache's Combined Log Format, which
+// log analyzers like GoAccess read as is.
+func combinedLogLine(r *http.Request, status int, bytes int64, start time.Time) string {
+	user, _, _ := r.BasicAuth()
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s "%s" "%s"`,
+		clientIP(r), clfField(user), start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, clfQuote(r.URL.RequestURI()), r.Proto, status, size,
+		clfQuote(cmp.Or(r.Referer(), "-")), clfQuote(cmp.Or(r.UserAgent(), "-")))
+}
+
+// clfField is v as a bare log field: "-" when empty, with spaces escaped.
+func clfField(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(clfQuote(v), " ", `\x20`)
+}
+
+// clfQuote escapes v for a quoted log field, as Apache does.
+func clfQuote(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(v)
+}
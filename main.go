@@ -1,107 +1,1408 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"embed"
+	"errors"
+	"flag"
 	"fmt"
-	"io/fs"
+	"image/color"
 	"log"
 	"net"
 	"net/http"
+	neturl "net/url"
+	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/getlantern/systray"
-	"github.com/skip2/go-qrcode"
+	"github.com/mstenq/dapptoon/server"
 )
 
+// Write .br and .gz siblings for the embedded build's text assets, which
+// the precompressed handler serves in place of compressing on the fly
+//go:generate go run ./tools/precompress dist
+
 //go:embed dist/*
 var distFiles embed.FS
 
 //go:embed tray_icon.png
 var iconData []byte
 
-var lanURL string
+var (
+	// urlMu guards the LAN addresses and URLs, which change with the network
+	urlMu    sync.RWMutex
+	lanIP    string
+	lanIP6   string
+	lanURL   string
+	lanURL6  string
+	localURL string
+
+	// lanURLHTTP is the plain-HTTP LAN URL when -tls-port serves HTTPS
+	// alongside it
+	lanURLHTTP string
+
+	// urlScheme and urlPort are what the LAN URLs are built from, and
+	// httpPort the extra plain-HTTP port, if any
+	urlScheme string
+	urlPort   int
+	httpPort  int
+
+	// cfg and tlsCert are kept so the server can be restarted as it started
+	cfg     config
+	tlsCert *tls.Certificate
+
+	// srvMu guards srvs and the bound ports, and serializes restarts
+	srvMu        sync.Mutex
+	srvs         []*server.Server
+	boundPort    int
+	boundTLSPort int
+)
+
+// browserWait is how long to watch a browser launcher for failure.
+const browserWait = 5 * time.Second
+
+// openBrowser opens url in the default browser, reporting a launcher that
+// fails within ctx, such as xdg-open with no browser set up. On Linux
+// $BROWSER is tried next.
+func openBrowser(ctx context.Context, url string) error {
+	switch runtime.GOOS {
+	case "linux":
+		err := launch(ctx, "xdg-open", url)
+		if err == nil {
+			return nil
+		}
+		// $BROWSER is a colon-separated list of commands, where %s, if
+		// present, stands for the URL
+		for _, command := range strings.Split(os.Getenv("BROWSER"), ":") {
+			args := strings.Fields(command)
+			if len(args) == 0 {
+				continue
+			}
+			if strings.Contains(command, "%s") {
+				for i := range args {
+					args[i] = strings.ReplaceAll(args[i], "%s", url)
+				}
+			} else {
+				args = append(args, url)
+			}
+			berr := launch(ctx, args[0], args[1:]...)
+			if berr == nil {
+				return nil
+			}
+			err = errors.Join(err, berr)
+		}
+		return err
+	case "windows":
+		// The URL reaches rundll32 as a single argument rather than going
+		// through cmd.exe, so an "&" in the query isn't taken as a command
+		// separator the way it is with "cmd /c start"
+		return launch(ctx, "rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		return launch(ctx, "open", url)
+	default:
+		return fmt.Errorf("don't know how to open a browser on %s", runtime.GOOS)
+	}
+}
+
+// launch runs a launcher command and waits until it exits or ctx is done,
+// returning its error output if it fails. One still running by then, like
+// a browser $BROWSER started in the foreground, is taken to have worked and
+// is left running.
+func launch(ctx context.Context, name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Reap the launcher so it doesn't linger as a zombie
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			return nil
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// browse opens url and reports a failure both in the log and as a
+// notification, since a tray click otherwise gives no feedback.
+func browse(url string) {
+	ctx, cancel := context.WithTimeout(context.Background(), browserWait)
+	defer cancel()
+	if err := openBrowser(ctx, url); err != nil {
+		warn("Failed to open browser: " + err.Error())
+		notify("Couldn't open a browser; the app is at " + url)
+	}
+}
+
+// pollEvery returns how often a background refresh that would run every d
+// runs: d itself, or with -low-power at least 30 times less often, and not
+// more than once a minute, so a laptop on battery rarely wakes up.
+func pollEvery(d time.Duration) time.Duration {
+	if cfg.LowPower {
+		return max(30*d, time.Minute)
+	}
+	return d
+}
+
+// allURLs lists every address the app can be reached at, labeled, one per
+// line, for pasting into a chat before a demo.
+func allURLs() string {
+	var b strings.Builder
+	add := func(label, url string) {
+		if url != "" {
+			fmt.Fprintf(&b, "%-7s %s\n", label+":", url)
+		}
+	}
+	url, url6 := currentLANURLs()
+	add("Local", localURL)
+	add("LAN", url)
+	if url6 != url {
+		add("IPv6", url6)
+	}
+	add("HTTP", currentHTTPURL())
+	if mdnsServer != nil {
+		add("mDNS", appURL(hostURL(urlScheme, cfg.MDNSName+".local", urlPort)))
+	}
+	add("Public", cfg.PublicURL)
+	return b.String()
+}
 
-func openBrowser(url string) {
-	var err error
+// markdownLink formats url as [label](url), escaping brackets in label.
+func markdownLink(label, url string) string {
+	label = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`).Replace(label)
+	return "[" + label + "](" + url + ")"
+}
+
+// openFolder shows dir in the OS file manager.
+func openFolder(dir string) {
+	if err := openFile(dir); err != nil {
+		log.Println("Failed to open folder:", err)
+	}
+}
+
+// openFile opens a file in its default app, or a folder in the file
+// manager. Unlike openBrowser it hands over a path, which URL handlers
+// don't reliably route to an image viewer.
+func openFile(name string) error {
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	var cmd *exec.Cmd
 	switch runtime.GOOS {
 	case "linux":
-		err = exec.Command("xdg-open", url).Start()
+		cmd = exec.Command("xdg-open", name)
 	case "windows":
-		err = exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+		cmd = exec.Command("explorer", name)
 	case "darwin":
-		err = exec.Command("open", url).Start()
+		cmd = exec.Command("open", name)
+	default:
+		return fmt.Errorf("don't know how to open files on %s", runtime.GOOS)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// showQR opens the QR page in the browser, falling back to the image in the
+// default viewer when no browser can be launched.
+func showQR() {
+	page := strings.TrimSuffix(localURL, "/") + "/qr"
+	ctx, cancel := context.WithTimeout(context.Background(), browserWait)
+	defer cancel()
+	err := openBrowser(ctx, page)
+	if err == nil {
+		return
+	}
+	log.Println("Failed to open browser:", err)
+	path, err := saveQR(cfg.QROut)
+	if err == nil {
+		err = openFile(path)
 	}
 	if err != nil {
-		log.Println("Failed to open browser:", err)
+		log.Println("Failed to open QR code:", err)
+		notify("Couldn't show the QR code; it's at " + page)
 	}
 }
 
-func getLANIP() string {
-	addrs, err := net.InterfaceAddrs()
+// waitUntilServing polls url over loopback until the server answers. Any
+// response counts, since auth or a pause still means it's listening.
+func waitUntilServing(url string, timeout time.Duration) error {
+	client := &http.Client{
+		Timeout: time.Second,
+		// The probe only checks liveness, so the self-signed cert is fine
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server not ready after %s: %w", timeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// config holds the options set on the command line.
+type config struct {
+	Port              int
+	Apps              []string
+	Dirs              []servedDir
+	Archives          []servedDir
+	Headers           []customHeader
+	CSP               string
+	RuntimeConfig     []runtimeSetting
+	Proxies           []proxyRoute
+	Redirects         []redirectRule
+	RedirectStatus    int
+	ShutdownToken     string
+	Unlisted          bool
+	Token             string
+	Lang              string
+	Title             string
+	Tooltip           string
+	Icon              string
+	TLS               bool
+	Auth              string
+	AllowCIDRs        []string
+	Gzip              bool
+	Brotli            bool
+	Notify            bool
+	NoTray            bool
+	NoQuit            bool
+	NoCopy            bool
+	NoQR              bool
+	QRTerminal        bool
+	NoColor           bool
+	Verbose           bool
+	CachePattern      string
+	BasePath          string
+	MDNSName          string
+	MDNSInterface     string
+	QROut             string
+	QRLevel           string
+	QRSize            int
+	QRFormat          string
+	WiFiSSID          string
+	WiFiPassword      string
+	WiFiSecurity      string
+	LiveReload        bool
+	WatchRestart      bool
+	WSEcho            bool
+	Events            bool
+	Info              bool
+	OnConnect         string
+	Exec              string
+	RestrictMethods   bool
+	H2C               bool
+	DualStack         bool
+	LinkLabel         string
+	DeviceCommand     string
+	LANIP             string
+	Reopen            bool
+	Uploads           string
+	Open              bool
+	PrintQR           bool
+	DryRun            bool
+	Strict            bool
+	LowPower          bool
+	CheckUpdate       bool
+	LogFormat         string
+	LogFile           string
+	LogMaxSize        int
+	TLSPort           int
+	TLSCertFile       string
+	TLSKeyFile        string
+	CORS              string
+	Bind              string
+	PublicURL         string
+	UnixSocket        string
+	BindRetries       int
+	Listing           bool
+	NoDirRedirect     bool
+	StrictPaths       bool
+	NoSymlinks        bool
+	Precompressed     bool
+	Index             string
+	LangIndexes       map[string]string
+	AssetVersions     bool
+	QRKeep            bool
+	ShutdownTimeout   time.Duration
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxBody           int64
+	Rate              float64
+	MaxConns          int
+	Throttle          int64
+	ServerTiming      bool
+	Burst             int
+}
+
+// startServer binds the listeners and serves the active site, returning the
+// servers and the ports actually bound, which differ from cfg's if they
+// were already taken. When cert is non-nil the server on cfg.Port speaks
+// HTTPS, unless cfg.TLSPort is set: then cfg.Port stays plain HTTP and
+// tlsPort is the HTTPS one, both sharing the same handler.
+func startServer(cfg config, cert *tls.Certificate) (servers []*server.Server, port, tlsPort int, err error) {
+	handler := newHandler(cfg)
+
+	if cfg.UnixSocket != "" {
+		s, _, err := serve(handler, cfg, 0, cert)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return []*server.Server{s}, 0, 0, nil
+	}
+
+	plainCert := cert
+	if cfg.TLSPort != 0 {
+		plainCert = nil
+	}
+	s, port, err := serve(handler, cfg, cfg.Port, plainCert)
 	if err != nil {
-		return ""
+		return nil, 0, 0, err
+	}
+	servers = append(servers, s)
+
+	if cfg.TLSPort != 0 {
+		s, tlsPort, err = serve(handler, cfg, cfg.TLSPort, cert)
+		if err != nil {
+			shutdownServer(servers[0])
+			return nil, 0, 0, err
+		}
+		servers = append(servers, s)
+	}
+	return servers, port, tlsPort, nil
+}
+
+// serve binds port on cfg.Bind (all interfaces if empty), falling back to a
+// free port if it's taken, and serves handler on it in the background.
+func serve(handler http.Handler, cfg config, port int, cert *tls.Certificate) (*server.Server, int, error) {
+	// Timeouts keep slow or stalled clients from holding connections open.
+	// Streams that must outlive WriteTimeout, like live reload, lift it
+	// for themselves.
+	srv := server.New(server.Options{
+		Handler:           handler,
+		Bind:              cfg.Bind,
+		Port:              port,
+		BindRetries:       cfg.BindRetries,
+		UnixSocket:        cfg.UnixSocket,
+		FallbackPort:      true,
+		TLSCert:           cert,
+		H2C:               cfg.H2C,
+		DualStack:         cfg.DualStack,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	})
+	// Event streams never go idle, so end them or Shutdown would wait
+	srv.RegisterOnShutdown(reloads.disconnectAll)
+	srv.RegisterOnShutdown(ipChanges.disconnectAll)
+	srv.RegisterOnShutdown(appEvents.disconnectAll)
+	// Bind synchronously so errors like a privileged port surface before the tray starts
+	if err := srv.Start(); err != nil {
+		return nil, 0, err
+	}
+	if srv.Port() != port && port != 0 {
+		warnf("Port %d is already in use, picked %d instead", port, srv.Port())
+	}
+	if cfg.DualStack {
+		if addrs := srv.Addrs(); len(addrs) == 2 {
+			log.Printf("Listening on %s and %s", addrs[0], addrs[1])
+		} else {
+			log.Printf("Listening on %s only; the other IP family isn't available", addrs[0])
+		}
+	}
+
+	go func() {
+		if err := srv.Wait(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	return srv, srv.Port(), nil
+}
+
+// newHandler builds the routes and middleware chain for cfg.
+func newHandler(cfg config) http.Handler {
+	hashed, err := regexp.Compile(cfg.CachePattern)
+	if err != nil {
+		log.Fatalf("Invalid -cache-pattern: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/qr", serveQR)
+	mux.HandleFunc("/qr/events", ipChanges.serveEvents)
+	mux.HandleFunc("/qr/wifi", serveWiFiQR)
+	mux.HandleFunc("/metrics", serveMetrics)
+	if cfg.WSEcho {
+		mux.HandleFunc("/ws-echo", serveWSEcho)
+	}
+	if cfg.Info {
+		mux.HandleFunc("GET /__info", serveInfo)
+	}
+	if cfg.Events {
+		mux.HandleFunc("GET /events", appEvents.serveEvents)
+		mux.HandleFunc("POST /broadcast", serveBroadcast)
+	}
+	if cfg.Uploads != "" {
+		mux.Handle("/upload", uploadHandler(cfg.Uploads))
+	}
+	if cfg.ShutdownToken != "" {
+		mux.Handle("POST /shutdown", shutdownEndpoint(cfg.ShutdownToken))
+	}
+	var files http.Handler = http.HandlerFunc(serveActiveSite)
+	// With -reopen=false the stream also shows whether a tab is open
+	if (cfg.LiveReload && len(cfg.Dirs) > 0) || !cfg.Reopen {
+		mux.HandleFunc("/livereload", reloads.serveEvents)
+		files = injectReload(cfg.BasePath+"/livereload", files)
+	}
+	if len(cfg.RuntimeConfig) > 0 {
+		files = injectConfig(cfg.RuntimeConfig, files)
+	}
+	if len(cfg.Redirects) > 0 {
+		files = redirects(cfg.Redirects, cfg.RedirectStatus, files)
+	}
+	// Proxied paths take precedence over files with the same prefix
+	for _, p := range cfg.Proxies {
+		proxy := newProxy(p)
+		mux.Handle(p.Prefix, proxy)
+		mux.Handle(p.Prefix+"/", proxy)
+	}
+	siteHandler := server.CacheHeaders(hashed, files)
+	mux.Handle("/", siteHandler)
+	mux.Handle("/favicon.ico", faviconFallback(siteHandler))
+	mux.Handle("/asset-hashes.json", assetHashes(siteHandler))
+
+	// Mount everything under the base path, e.g. behind a proxy at /app/
+	var app http.Handler = mux
+	if cfg.BasePath != "" {
+		outer := http.NewServeMux()
+		outer.Handle(cfg.BasePath+"/", http.StripPrefix(cfg.BasePath, mux))
+		// An unlisted share doesn't point the way to its token
+		if cfg.Token == "" {
+			outer.Handle("/{$}", http.RedirectHandler(cfg.BasePath+"/", http.StatusFound))
+		}
+		app = outer
+	}
+
+	var handler http.Handler = countActive(pauseGate(app))
+	if cfg.MaxConns > 0 {
+		handler = limitConcurrent(cfg.MaxConns, handler)
+	}
+	var encodings []string
+	if cfg.Brotli {
+		encodings = append(encodings, "br")
+	}
+	if cfg.Gzip {
+		encodings = append(encodings, "gzip")
+	}
+	if len(encodings) > 0 {
+		handler = server.Compress(encodings, handler)
+	}
+	// Outside compression, so it's the bytes on the wire that are slowed
+	if cfg.Throttle > 0 {
+		handler = newThrottle(cfg.Throttle).middleware(handler)
+	}
+	handler = server.HeadAsGet(handler)
+	if cfg.Auth != "" {
+		user, pass, _ := strings.Cut(cfg.Auth, ":")
+		handler = server.BasicAuth(user, pass, handler)
 	}
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ip4 := ipnet.IP.To4(); ip4 != nil {
-				return ip4.String()
+	// Inside CORS, which answers preflights before they get here
+	if cfg.RestrictMethods {
+		var exempt []string
+		for _, p := range cfg.Proxies {
+			exempt = append(exempt, cfg.BasePath+p.Prefix)
+		}
+		if cfg.ShutdownToken != "" {
+			exempt = append(exempt, cfg.BasePath+"/shutdown")
+		}
+		if cfg.Uploads != "" {
+			exempt = append(exempt, cfg.BasePath+"/upload")
+		}
+		if cfg.Events {
+			exempt = append(exempt, cfg.BasePath+"/broadcast")
+		}
+		handler = allowMethods(exempt, handler)
+	}
+	// Outside auth, since browsers send preflights without credentials
+	if cfg.CORS != "" {
+		handler = server.CORS(strings.Split(cfg.CORS, ","), handler)
+	}
+	if cfg.Notify || cfg.OnConnect != "" {
+		devices := newDeviceTracker(time.Minute, func(ip string) {
+			if cfg.Notify {
+				notify("New device connected: " + ip)
+			}
+			if cfg.OnConnect != "" {
+				runConnectHook(cfg.OnConnect, ip)
 			}
+		})
+		handler = devices.middleware(handler)
+	}
+	if cfg.Rate > 0 {
+		handler = newRateLimiter(cfg.Rate, cfg.Burst).middleware(handler)
+	}
+	if cfg.MaxBody > 0 {
+		var exempt []string
+		for _, p := range cfg.Proxies {
+			exempt = append(exempt, cfg.BasePath+p.Prefix)
+		}
+		handler = limitBody(cfg.MaxBody, exempt, handler)
+	}
+	if len(cfg.AllowCIDRs) > 0 {
+		nets, err := parseCIDRs(cfg.AllowCIDRs)
+		if err != nil {
+			log.Fatalf("Invalid -allow network: %v", err)
+		}
+		handler = allowlist(nets, handler)
+	}
+	headers := cfg.Headers
+	if policy, _ := cspPolicy(cfg.CSP); policy != "" {
+		// First, so a -header Content-Security-Policy replaces it
+		headers = append([]customHeader{{Name: "Content-Security-Policy", Value: policy}}, headers...)
+	}
+	if len(headers) > 0 {
+		handler = customHeaders(headers, handler)
+	}
+	handler = countMetrics(handler)
+	if cfg.ServerTiming {
+		handler = serverTiming(handler)
+	}
+	handler = logRequests(handler)
+	handler = server.Recover(func() http.FileSystem { return activeSite.Load().root }, handler)
+	handler = requestID(handler)
+	return handler
+}
+
+// shutdownServer stops s gracefully, then force-closes whatever connections
+// are still open once -shutdown-timeout has passed.
+func shutdownServer(s *server.Server) {
+	if s == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Printf("Shutdown timed out after %s, closing remaining connections", cfg.ShutdownTimeout)
+		s.Close()
+	case err != nil:
+		log.Println("Server shutdown:", err)
+	default:
+		log.Println("Server shut down cleanly")
+	}
+}
+
+// stopServer shuts down the running servers.
+func stopServer() {
+	srvMu.Lock()
+	defer srvMu.Unlock()
+	for _, s := range srvs {
+		shutdownServer(s)
+	}
+	srvs = nil
+}
+
+// quitRequested asks main to quit the way the tray's Quit item does.
+var quitRequested = make(chan struct{}, 1)
+
+// shutdownEndpoint quits the app for requests carrying token, so scripts
+// can stop a headless server without signals. It answers 202 before the
+// graceful shutdown starts.
+func shutdownEndpoint(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Shutdown-Token")), []byte(token)) != 1 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		log.Println("Shutdown requested by", clientIP(r))
+		select {
+		case quitRequested <- struct{}{}:
+		default:
 		}
+	})
+}
+
+// shutdown releases everything the app holds on the network before exit.
+func shutdown() {
+	logEvent("shutdown", "")
+	stopMDNS()
+	stopServer()
+	stopCompanion()
+	removeSessionQR()
+}
+
+// restartServer replaces the running servers with fresh ones on the same
+// ports, for when the app gets into a bad state.
+func restartServer() error {
+	srvMu.Lock()
+	defer srvMu.Unlock()
+
+	for _, s := range srvs {
+		shutdownServer(s)
+	}
+	srvs = nil
+
+	c := cfg
+	c.Port, c.TLSPort = boundPort, boundTLSPort
+	if c.Bind = bindAddr(); c.Bind != "" {
+		// One loopback address, as -bind would give
+		c.DualStack = false
+	}
+	servers, port, tlsPort, err := startServer(c, tlsCert)
+	if err != nil {
+		warn("Failed to restart server: " + err.Error())
+		return err
+	}
+	if port != boundPort {
+		warnf("Port %d was taken during restart, now serving on %d", boundPort, port)
+	}
+	if tlsPort != boundTLSPort {
+		warnf("Port %d was taken during restart, now serving HTTPS on %d", boundTLSPort, tlsPort)
 	}
-	return ""
+	srvs, boundPort, boundTLSPort = servers, port, tlsPort
+	logEvent("server_restart", "Server restarted", "port", port)
+	return nil
 }
 
-func startServer() {
-	// Get the embedded dist subdirectory
-	distFS, err := fs.Sub(distFiles, "dist")
+// setLANAccess restarts the server on all interfaces, or on loopback only
+// to hide it from the network, keeping the port, and starts or stops the
+// mDNS advertisement to match. When the restart fails it goes back to the
+// old address and returns the error.
+func setLANAccess(on bool) error {
+	bind := "127.0.0.1"
+	if on {
+		bind = ""
+	}
+	prev := bindOverride.Swap(&bind)
+	if on {
+		log.Println("LAN access on, restarting on all interfaces")
+	} else {
+		log.Println("LAN access off, restarting on 127.0.0.1 only")
+	}
+	if err := restartServer(); err != nil {
+		// Go back to serving as before, if the old address can still be had
+		bindOverride.Store(prev)
+		serverFailed.Store(restartServer() != nil)
+		return err
+	}
+	serverFailed.Store(false)
+
+	// Devices can't reach an address that only loopback listens on
+	if !on {
+		stopMDNS()
+	} else if cfg.MDNSName != "" && cfg.UnixSocket == "" {
+		v4, v6 := currentLANIPs()
+		startMDNS(urlPort, v4, v6)
+	}
+	return nil
+}
+
+// restartOnChange restarts the server for -watch-restart after a served
+// folder changes.
+func restartOnChange() {
+	log.Println("Served folder changed, restarting server")
+	err := restartServer()
 	if err != nil {
-		log.Fatal("Failed to get dist subdirectory:", err)
+		notify("Server failed to restart: " + err.Error())
+	}
+	serverFailed.Store(err != nil)
+	showState()
+}
+
+// setLANIPs records the current LAN addresses and rebuilds the URLs
+// derived from them.
+func setLANIPs(v4, v6 string) {
+	urlMu.Lock()
+	defer urlMu.Unlock()
+
+	lanIP, lanIP6 = v4, v6
+	lanURL6 = ""
+	if v6 != "" {
+		lanURL6 = appURL(hostURL(urlScheme, v6, urlPort))
+	}
+	if v4 != "" {
+		lanURL = appURL(hostURL(urlScheme, v4, urlPort))
+	} else {
+		// IPv6-only network
+		lanURL = lanURL6
+	}
+
+	lanURLHTTP = ""
+	if httpPort != 0 {
+		if host := cmp.Or(v4, v6); host != "" {
+			lanURLHTTP = appURL(hostURL("http", host, httpPort))
+		}
+	}
+}
+
+// currentLANIPs returns the LAN addresses last detected.
+func currentLANIPs() (v4, v6 string) {
+	urlMu.RLock()
+	defer urlMu.RUnlock()
+	return lanIP, lanIP6
+}
+
+// shareURL is the URL other devices are given: -public-url when set, such
+// as a tunnel for a phone on cellular, or else the LAN URL.
+func shareURL() string {
+	if cfg.PublicURL != "" {
+		return cfg.PublicURL
+	}
+	// Without the poller, -low-power checks the network when the URL is
+	// wanted, at most every few seconds
+	if now := time.Now().UnixNano(); cfg.LowPower && cfg.UnixSocket == "" && now-lastIPCheck.Load() > int64(5*time.Second) {
+		lastIPCheck.Store(now)
+		refreshLANIP()
+	}
+	url, _ := currentLANURLs()
+	return url
+}
+
+// currentLANURLs returns the URLs other devices can reach the app at.
+func currentLANURLs() (v4, v6 string) {
+	urlMu.RLock()
+	defer urlMu.RUnlock()
+	return lanURL, lanURL6
+}
+
+// currentHTTPURL returns the plain-HTTP LAN URL, or "" unless both HTTP
+// and HTTPS are served.
+func currentHTTPURL() string {
+	urlMu.RLock()
+	defer urlMu.RUnlock()
+	return lanURLHTTP
+}
+
+// appURL appends the base path to a server origin.
+func appURL(origin string) string {
+	if cfg.BasePath == "" {
+		return origin
+	}
+	return origin + cfg.BasePath + "/"
+}
+
+// printQROnly prints the QR code for the LAN URL the server would use, and
+// saves it when -qr-out is set, without binding the port, so it also works
+// for sharing another server running on that port.
+func printQROnly() {
+	urlScheme, urlPort = "http", cfg.Port
+	if cfg.TLSPort != 0 {
+		urlScheme, urlPort = "https", cfg.TLSPort
+	} else if cfg.TLS {
+		urlScheme = "https"
+	}
+	setLANIPs(lanIPs())
+	warnIfLocalOnly()
+
+	url := shareURL()
+	if url == "" {
+		log.Fatal("No LAN IP available to encode")
+	}
+	printWiFiQR()
+	say(url)
+	printQR(url)
+	if cfg.QROut != "" {
+		if _, err := saveQR(cfg.QROut); err != nil {
+			log.Fatalf("Failed to save QR code: %v", err)
+		}
+		say("QR code saved to", cfg.QROut)
+	}
+}
+
+// addSiteMenu adds a submenu of the served folders that works like a radio
+// group: picking one checks it, makes it the active site, and calls picked.
+func addSiteMenu(picked func()) {
+	mSites := systray.AddMenuItem(tr("Served Folder"), tr("Switch which folder is served"))
+	items := make([]*systray.MenuItem, len(sites))
+	for i, st := range sites {
+		items[i] = mSites.AddSubMenuItemCheckbox(st.name, st.dir, st == activeSite.Load())
+	}
+	for i, item := range items {
+		go func() {
+			for range item.ClickedCh {
+				activeSite.Store(sites[i])
+				reloads.broadcast()
+				for _, other := range items {
+					if other == item {
+						other.Check()
+					} else {
+						other.Uncheck()
+					}
+				}
+				if dir := sites[i].dir; dir != "" {
+					log.Printf("Now serving %s (%s)", sites[i].name, dir)
+				} else {
+					log.Println("Now serving", sites[i].name)
+				}
+				picked()
+			}
+		}()
+	}
+}
+
+// showLANState titles the URL item after url and, when there's no LAN
+// address (offline, or a captive portal) or LAN access is off, disables
+// the LAN-only items rather than have them share a broken link.
+func showLANState(url string, mURL *systray.MenuItem, lanItems []*systray.MenuItem) {
+	hidden := localOnly() && cfg.PublicURL == ""
+	switch {
+	case hidden:
+		mURL.SetTitle(tr("LAN access off (local only)"))
+	case url == "":
+		mURL.SetTitle(tr("No LAN IP (local only)"))
+	default:
+		mURL.SetTitle(url)
+	}
+	for _, item := range lanItems {
+		if url == "" || hidden {
+			item.Disable()
+		} else {
+			item.Enable()
+		}
+	}
+}
+
+// updateStatus refreshes the tray every couple of seconds: the URL and
+// LAN-only items follow LAN IP changes, and the tooltip shows the number of
+// in-flight requests as a passive activity heartbeat.
+func updateStatus(mURL *systray.MenuItem, lanItems []*systray.MenuItem) {
+	lastURL := shareURL()
+	var lastTooltip string
+	for range time.Tick(pollEvery(2 * time.Second)) {
+		url := shareURL()
+		if url != lastURL {
+			lastURL = url
+			showLANState(url, mURL, lanItems)
+		}
+
+		tooltip := fmt.Sprintf(tr("Serving at %s"), url)
+		if url == "" {
+			tooltip = fmt.Sprintf(tr("Serving at %s (no LAN IP)"), localURL)
+		}
+		if firewallBlocked.Load() {
+			tooltip += tr(" (firewall may block LAN access)")
+		}
+		switch n := activeRequests.Load(); n {
+		case 0:
+		case 1:
+			tooltip += tr(" — 1 active request")
+		default:
+			tooltip += fmt.Sprintf(tr(" — %d active requests"), n)
+		}
+		if cfg.Tooltip != "" {
+			tooltip = cfg.Tooltip + " — " + tooltip
+		}
+		if tooltip != lastTooltip {
+			lastTooltip = tooltip
+			systray.SetTooltip(tooltip)
+		}
+	}
+}
+
+// addLANIPMenu adds a submenu of the machine's LAN addresses, for when the
+// automatic pick is one other devices can't reach. Picking one puts it in
+// the URL, clipboard, and QR code; the list follows network changes.
+func addLANIPMenu() {
+	mLAN := systray.AddMenuItem(tr("LAN IP"), tr("Choose the address other devices are given"))
+	mAuto := mLAN.AddSubMenuItemCheckbox(tr("Automatic"), tr("Pick the address most likely to be reachable"), cfg.LANIP == "")
+	// Fixed slots, since items can be hidden but not removed
+	slots := make([]*systray.MenuItem, 6)
+	ips := make([]string, len(slots))
+	var mu sync.Mutex
+	for i := range slots {
+		slots[i] = mLAN.AddSubMenuItemCheckbox("", "", false)
+		slots[i].Hide()
 	}
 
-	// Serve the embedded files
-	fs := http.FileServer(http.FS(distFS))
-	http.Handle("/", fs)
+	refresh := func() {
+		candidates := lanCandidates(false)
+		chosen := chosenLANIP()
+		mu.Lock()
+		defer mu.Unlock()
+		for i, item := range slots {
+			if i >= len(candidates) {
+				ips[i] = ""
+				item.Hide()
+				continue
+			}
+			ips[i] = candidates[i].ip.String()
+			item.SetTitle(fmt.Sprintf("%s (%s)", ips[i], candidates[i].iface))
+			if chosen.Equal(candidates[i].ip) {
+				item.Check()
+			} else {
+				item.Uncheck()
+			}
+			item.Show()
+		}
+		if chosen == nil {
+			mAuto.Check()
+		} else {
+			mAuto.Uncheck()
+		}
+	}
+	pick := func(ip string) {
+		chooseLANIP(ip)
+		refreshLANIP()
+		refresh()
+	}
+
+	go func() {
+		for range mAuto.ClickedCh {
+			pick("")
+		}
+	}()
+	for i, item := range slots {
+		go func() {
+			for range item.ClickedCh {
+				mu.Lock()
+				ip := ips[i]
+				mu.Unlock()
+				if ip != "" {
+					pick(ip)
+				}
+			}
+		}()
+	}
+	go func() {
+		for ; ; time.Sleep(pollEvery(5 * time.Second)) {
+			refresh()
+		}
+	}()
+}
+
+// addStatsMenu adds a submenu showing uptime, requests served, and peak
+// concurrency, refreshed every few seconds.
+func addStatsMenu() {
+	mStats := systray.AddMenuItem(tr("Stats"), tr("Uptime and requests served"))
+	mUptime := mStats.AddSubMenuItem("", "")
+	mRequests := mStats.AddSubMenuItem("", "")
+	mPeak := mStats.AddSubMenuItem("", "")
+	mActive := &systray.MenuItem{}
+	if cfg.MaxConns > 0 {
+		mActive = mStats.AddSubMenuItem("", "")
+		mActive.Disable()
+	}
+	mTop := mStats.AddSubMenuItem(tr("Top devices:"), tr("Clients that downloaded the most"))
+	// Filled in as devices show up
+	mDevices := make([]*systray.MenuItem, 3)
+	for i := range mDevices {
+		mDevices[i] = mStats.AddSubMenuItem("", "")
+		mDevices[i].Hide()
+	}
+	for _, item := range append([]*systray.MenuItem{mUptime, mRequests, mPeak, mTop}, mDevices...) {
+		item.Disable()
+	}
+	go func() {
+		for ; ; time.Sleep(pollEvery(5 * time.Second)) {
+			mUptime.SetTitle(fmt.Sprintf(tr("Uptime: %s"), time.Since(startTime).Round(time.Second)))
+			mRequests.SetTitle(fmt.Sprintf(tr("Requests: %d"), metrics.requests.Load()))
+			mPeak.SetTitle(fmt.Sprintf(tr("Peak concurrent: %d"), peakRequests.Load()))
+			if cfg.MaxConns > 0 {
+				mActive.SetTitle(fmt.Sprintf(tr("Active: %d of %d (%d turned away)"), activeRequests.Load(), cfg.MaxConns, busyRequests.Load()))
+			}
+			top := topDevices(len(mDevices))
+			for i, item := range mDevices {
+				if i < len(top) {
+					item.SetTitle(fmt.Sprintf("%s: %s", top[i].ip, formatSize(top[i].bytes)))
+					item.Show()
+				} else {
+					item.Hide()
+				}
+			}
+		}
+	}()
+}
 
+// addDiagnosticsMenu adds a submenu showing whether the server is
+// listening, the LAN IP, and the latest warnings and errors, so problems
+// that would only reach the log are visible from the tray.
+func addDiagnosticsMenu() {
+	mDiag := systray.AddMenuItem(tr("Diagnostics"), tr("Recent warnings and errors"))
+	mServer := mDiag.AddSubMenuItem("", "")
+	mIP := mDiag.AddSubMenuItem("", "")
+	mNone := mDiag.AddSubMenuItem(tr("No problems so far"), "")
+	// Fixed slots, newest first, since items can be hidden but not removed
+	slots := make([]*systray.MenuItem, 5)
+	for i := range slots {
+		slots[i] = mDiag.AddSubMenuItem("", "")
+		slots[i].Hide()
+	}
+	for _, item := range append([]*systray.MenuItem{mServer, mIP, mNone}, slots...) {
+		item.Disable()
+	}
 	go func() {
-		log.Fatal(http.ListenAndServe(":8000", nil))
+		for {
+			srvMu.Lock()
+			port := boundPort
+			srvMu.Unlock()
+			if serverFailed.Load() {
+				mServer.SetTitle(tr("Server: not running"))
+			} else {
+				mServer.SetTitle(fmt.Sprintf(tr("Server: listening on port %d"), port))
+			}
+			v4, v6 := currentLANIPs()
+			mIP.SetTitle(fmt.Sprintf(tr("LAN IP: %s"), cmp.Or(v4, v6, tr("none"))))
+
+			recent := recentProblems(len(slots))
+			if len(recent) == 0 {
+				mNone.Show()
+			} else {
+				mNone.Hide()
+			}
+			for i, item := range slots {
+				if i >= len(recent) {
+					item.Hide()
+					continue
+				}
+				msg := recent[i].msg
+				// Menus don't wrap, so keep long messages to a readable width
+				if r := []rune(msg); len(r) > 80 {
+					msg = string(r[:79]) + "…"
+				}
+				item.SetTitle(recent[i].at.Format(time.TimeOnly) + " " + msg)
+				item.SetTooltip(recent[i].msg)
+				item.Show()
+			}
+
+			select {
+			case <-problemsChanged:
+			case <-time.After(pollEvery(5 * time.Second)):
+			}
+		}
 	}()
 }
 
+// Tray icons for each server state, derived from the embedded icon.
+var (
+	servingIcon []byte
+	pausedIcon  []byte
+	failedIcon  []byte
+)
+
+// lastIPCheck is when shareURL last refreshed the LAN IP, in Unix
+// nanoseconds.
+var lastIPCheck atomic.Int64
+
+// serverFailed is set when a restart couldn't bind, so no server is running.
+var serverFailed atomic.Bool
+
+// showState sets the tray icon to match the server: red if it failed to
+// bind, yellow while paused, and green while serving. Without a tray, as
+// with -no-tray, there's no icon to set.
+func showState() {
+	select {
+	case <-trayReady:
+	default:
+		return
+	}
+	switch {
+	case serverFailed.Load():
+		systray.SetIcon(failedIcon)
+	case paused.Load():
+		systray.SetIcon(pausedIcon)
+	default:
+		systray.SetIcon(servingIcon)
+	}
+}
+
 func onReady() {
-	// Use embedded icon
-	systray.SetIcon(iconData)
-	systray.SetTitle("React Server")
-	systray.SetTooltip("Serving your React app")
+	close(trayReady)
+	servingIcon = badgeIcon(iconData, color.NRGBA{52, 199, 89, 255})
+	pausedIcon = badgeIcon(dimIcon(iconData), color.NRGBA{255, 204, 0, 255})
+	failedIcon = badgeIcon(iconData, color.NRGBA{255, 59, 48, 255})
+	showState()
+	systray.SetTitle(cmp.Or(cfg.Title, tr("React Server")))
+	systray.SetTooltip(cmp.Or(cfg.Tooltip, tr("Serving your React app")))
 
-	mOpen := systray.AddMenuItem("Open App", "Open in browser")
-	mCopy := systray.AddMenuItem("Copy LAN URL", "Copy link to clipboard")
-	mQR := systray.AddMenuItem("Show QR Code", "Open QR code for phone")
-	mQuit := systray.AddMenuItem("Quit", "Stop the server")
+	// The URL at a glance; it's informational, so it can't be clicked
+	url := shareURL()
+	mURL := systray.AddMenuItem("", tr("The app's LAN address"))
+	mURL.Disable()
+	systray.AddMenuItem(fmt.Sprintf(tr("Build: %s"), embeddedBuildVersion()), tr("Version of the embedded front-end build")).Disable()
+	systray.AddSeparator()
+
+	// Items that -no-copy, -no-qr, and -no-quit hide stay as placeholders
+	// whose clicks never fire, and are left out of lanItems
+	var lanItems []*systray.MenuItem
+	mOpen := systray.AddMenuItem(tr("Open App"), tr("Open in browser"))
+	// On a Unix socket only the proxy in front knows the app's address
+	if localURL == "" {
+		mOpen.Disable()
+	}
+	mCopy, mCopyMD, mCopyCmd, mCopyLocal, mCopyAll, mCopy6, mCopyHTTP := &systray.MenuItem{}, &systray.MenuItem{}, &systray.MenuItem{}, &systray.MenuItem{}, &systray.MenuItem{}, &systray.MenuItem{}, &systray.MenuItem{}
+	if !cfg.NoCopy {
+		mCopy = systray.AddMenuItem(tr("Copy LAN URL"), tr("Copy link to clipboard"))
+		mCopyMD = systray.AddMenuItem(tr("Copy as Markdown Link"), tr("Copy the LAN link formatted for docs and chat"))
+		if cfg.DeviceCommand != "" {
+			mCopyCmd = systray.AddMenuItem(tr("Copy Device Command"), tr("Copy a command that opens the LAN link on a connected phone"))
+			lanItems = append(lanItems, mCopyCmd)
+		}
+		mCopyLocal = systray.AddMenuItem(tr("Copy Local URL"), tr("Copy localhost link to clipboard"))
+		mCopyAll = systray.AddMenuItem(tr("Copy All URLs"), tr("Copy every address the app is at, one per line"))
+		lanItems = append(lanItems, mCopy, mCopyMD)
+		if localURL == "" {
+			mCopyLocal.Disable()
+		}
+		// Only offer the IPv6 link when the machine has a usable address
+		if url, url6 := currentLANURLs(); url6 != "" && url6 != url {
+			mCopy6 = systray.AddMenuItem(tr("Copy IPv6 URL"), tr("Copy IPv6 link to clipboard"))
+		}
+		// Serving both protocols, offer the plain-HTTP link too
+		if currentHTTPURL() != "" {
+			mCopyHTTP = systray.AddMenuItem(tr("Copy HTTP URL"), tr("Copy plain-HTTP link to clipboard"))
+		}
+	}
+	// The embedded build has no folder on disk to show
+	mFolder := &systray.MenuItem{}
+	if activeSite.Load().dir != "" {
+		mFolder = systray.AddMenuItem(tr("Open Folder"), tr("Show the served folder in the file manager"))
+	}
+	mQR, mCopyQR, mSaveQR, mRegenQR := &systray.MenuItem{}, &systray.MenuItem{}, &systray.MenuItem{}, &systray.MenuItem{}
+	if !cfg.NoQR {
+		mQR = systray.AddMenuItem(tr("Show QR Code"), tr("Open QR code for phone"))
+		mCopyQR = systray.AddMenuItem(tr("Copy QR Image"), tr("Copy the QR code image to the clipboard"))
+		mSaveQR = systray.AddMenuItem(tr("Save QR Code…"), tr("Save the QR code as an image file"))
+		mRegenQR = systray.AddMenuItem(tr("Regenerate QR Now"), tr("Detect the LAN IP again and show a fresh QR code"))
+		lanItems = append(lanItems, mQR, mCopyQR, mSaveQR)
+	}
+	mPause := systray.AddMenuItem(tr("Pause Serving"), tr("Temporarily answer all requests with 503"))
+	mRestart := systray.AddMenuItem(tr("Restart Server"), tr("Restart the HTTP server"))
+	// Only an all-interfaces or loopback -bind has a counterpart to switch to
+	mLAN := &systray.MenuItem{}
+	if cfg.UnixSocket == "" && (cfg.Bind == "" || localOnly()) {
+		mLAN = systray.AddMenuItemCheckbox(tr("LAN Access"), tr("Let other devices on the network connect"), !localOnly())
+	}
+	mVerbose := systray.AddMenuItemCheckbox(tr("Verbose Logging"), tr("Log every request"), verboseLogs.Load())
+	// With -dir, the build shipped in the binary can stand in for the
+	// folder, to compare the two
+	mLive := &systray.MenuItem{}
+	if shipped != nil {
+		mLive = systray.AddMenuItemCheckbox(tr("Use Live Folder"), tr("Serve the -dir folder rather than the build inside the app"), true)
+	}
+	if len(sites) > 1 {
+		addSiteMenu(func() {
+			if shipped != nil {
+				mLive.Check()
+			}
+		})
+	}
+	// Without a LAN address of its own to pick, the menu wouldn't change
+	// what other devices are given
+	if cfg.UnixSocket == "" && boundLANIP() == nil && cfg.PublicURL == "" {
+		addLANIPMenu()
+	}
+	addStatsMenu()
+	addDiagnosticsMenu()
+	mInfo := &systray.MenuItem{}
+	if !cfg.NoCopy {
+		mInfo = systray.AddMenuItem(tr("Copy Server Info"), tr("Copy diagnostics for a bug report"))
+	}
+	// Without the item, Ctrl-C, a signal, or POST /shutdown still quit
+	mQuit := &systray.MenuItem{}
+	if !cfg.NoQuit {
+		mQuit = systray.AddMenuItem(tr("Quit"), tr("Stop the server"))
+	}
+
+	showLANState(url, mURL, lanItems)
+	go updateStatus(mURL, lanItems)
 
 	go func() {
+		var live *site
 		for {
 			select {
 			case <-mOpen.ClickedCh:
-				openBrowser("http://localhost:8000")
+				// Browsers don't let another program focus a tab, so at least
+				// don't stack up duplicates
+				if !cfg.Reopen && reloads.hasLocal() {
+					notify("The app is already open in your browser")
+					continue
+				}
+				// Watching the launcher for failure mustn't hold up the menu
+				go browse(localURL)
 			case <-mCopy.ClickedCh:
-				if runtime.GOOS == "windows" {
-					exec.Command("cmd", "/c", "echo "+lanURL+"| clip").Run()
-				} else if runtime.GOOS == "darwin" {
-					cmd := exec.Command("pbcopy")
-					cmd.Stdin = strings.NewReader(lanURL)
-					cmd.Run()
-				} else {
-					cmd := exec.Command("xclip", "-selection", "clipboard")
-					cmd.Stdin = strings.NewReader(lanURL)
-					cmd.Run()
+				warnIfLocalOnly()
+				if err := copyToClipboard(shareURL()); err != nil {
+					log.Println("Failed to copy LAN URL:", err)
+				}
+			case <-mCopyMD.ClickedCh:
+				warnIfLocalOnly()
+				if err := copyToClipboard(markdownLink(cfg.LinkLabel, shareURL())); err != nil {
+					log.Println("Failed to copy Markdown link:", err)
+				}
+			case <-mCopyCmd.ClickedCh:
+				warnIfLocalOnly()
+				if err := copyToClipboard(strings.ReplaceAll(cfg.DeviceCommand, "{url}", shareURL())); err != nil {
+					log.Println("Failed to copy device command:", err)
+				}
+			case <-mCopyLocal.ClickedCh:
+				if err := copyToClipboard(localURL); err != nil {
+					log.Println("Failed to copy local URL:", err)
+				}
+			case <-mCopyAll.ClickedCh:
+				if err := copyToClipboard(allURLs()); err != nil {
+					log.Println("Failed to copy URLs:", err)
+				}
+			case <-mCopy6.ClickedCh:
+				_, url6 := currentLANURLs()
+				if err := copyToClipboard(url6); err != nil {
+					log.Println("Failed to copy IPv6 URL:", err)
+				}
+			case <-mCopyHTTP.ClickedCh:
+				if err := copyToClipboard(currentHTTPURL()); err != nil {
+					log.Println("Failed to copy HTTP URL:", err)
+				}
+			case <-mFolder.ClickedCh:
+				// The embedded build has no folder to show
+				if dir := activeSite.Load().dir; dir != "" {
+					openFolder(dir)
 				}
 			case <-mQR.ClickedCh:
-				file := "lan_qr.png"
-				_ = qrcode.WriteFile(lanURL, qrcode.Medium, 256, file)
-				openBrowser(file) // opens image viewer
+				warnIfLocalOnly()
+				go showQR()
+			case <-mCopyQR.ClickedCh:
+				warnIfLocalOnly()
+				png, err := qrPNG()
+				if err == nil {
+					err = copyImageToClipboard(png)
+				}
+				if err == nil {
+					continue
+				}
+				// Still get the image to the user when the clipboard can't take it
+				log.Println("Failed to copy QR image:", err)
+				path, err := saveQR(cfg.QROut)
+				if err != nil {
+					log.Println("Failed to save QR code:", err)
+					continue
+				}
+				notify("Couldn't copy the QR image, saved it to " + path)
+			case <-mRegenQR.ClickedCh:
+				// The poller may not have noticed a network switch yet
+				if cfg.UnixSocket == "" {
+					refreshLANIP()
+				}
+				url := shareURL()
+				if url == "" {
+					log.Println("No LAN IP available to encode")
+					notify("No LAN IP available for a QR code")
+					continue
+				}
+				log.Println("QR code encodes", url)
+				warnIfLocalOnly()
+				go showQR()
+			case <-mSaveQR.ClickedCh:
+				path, err := saveQR(cfg.QROut)
+				if err != nil {
+					log.Println("Failed to save QR code:", err)
+					continue
+				}
+				log.Println("QR code saved to", path)
+				notify("QR code saved to " + path)
+			case <-mPause.ClickedCh:
+				if paused.Load() {
+					paused.Store(false)
+					mPause.SetTitle(tr("Pause Serving"))
+				} else {
+					paused.Store(true)
+					mPause.SetTitle(tr("Resume Serving"))
+				}
+				showState()
+			case <-mLive.ClickedCh:
+				// The folder to go back to, the one the Served Folder menu picked
+				if active := activeSite.Load(); active != shipped {
+					live = active
+					activeSite.Store(shipped)
+					mLive.Uncheck()
+					log.Println("Now serving the embedded build")
+				} else {
+					activeSite.Store(live)
+					mLive.Check()
+					log.Printf("Now serving %s (%s)", live.name, live.dir)
+				}
+				reloads.broadcast()
+			case <-mVerbose.ClickedCh:
+				if verboseLogs.Load() {
+					verboseLogs.Store(false)
+					mVerbose.Uncheck()
+					log.Println("Request logging off")
+				} else {
+					verboseLogs.Store(true)
+					mVerbose.Check()
+					log.Println("Request logging on")
+				}
+			case <-mLAN.ClickedCh:
+				mLAN.Disable()
+				on := !mLAN.Checked()
+				go func() {
+					if err := setLANAccess(on); err != nil {
+						notify("Failed to change LAN access: " + err.Error())
+						on = !on
+					}
+					showState()
+					if on {
+						mLAN.Check()
+					} else {
+						mLAN.Uncheck()
+					}
+					showLANState(shareURL(), mURL, lanItems)
+					mLAN.Enable()
+				}()
+			case <-mRestart.ClickedCh:
+				mRestart.Disable()
+				go func() {
+					err := restartServer()
+					if err != nil {
+						notify("Server failed to restart: " + err.Error())
+					}
+					serverFailed.Store(err != nil)
+					showState()
+					mRestart.Enable()
+				}()
+			case <-mInfo.ClickedCh:
+				info := diagnostics()
+				log.Print("Server info:\n", info)
+				if err := copyToClipboard(info); err != nil {
+					log.Println("Failed to copy server info:", err)
+					continue
+				}
+				notify("Server info copied to the clipboard")
 			case <-mQuit.ClickedCh:
+				shutdown()
 				systray.Quit()
 				return
 			}
@@ -110,13 +1411,523 @@ func onReady() {
 }
 
 func main() {
-	port := 8000
-	lanIP := getLANIP()
-	lanURL = fmt.Sprintf("http://%s:%d", lanIP, port)
+	flag.IntVar(&cfg.Port, "port", 8000, "port to serve on")
+	flag.StringVar(&cfg.Bind, "bind", "", "listen only on this `address`, e.g. 127.0.0.1 (default: all interfaces)")
+	flag.BoolVar(&cfg.DualStack, "dual-stack", false, "listen on IPv4 and IPv6 with separate sockets and advertise both LAN URLs, for dual-stack networks (a missing family is skipped)")
+	flag.StringVar(&cfg.UnixSocket, "unix-socket", "", "listen on a Unix socket at this `path` instead of a TCP port, for a local reverse proxy (disables the LAN features)")
+	flag.StringVar(&cfg.LANIP, "lan-ip", "", "give other devices this `address` instead of the automatic pick, e.g. on a machine with several networks")
+	flag.StringVar(&cfg.PublicURL, "public-url", "", "put this `URL` in the QR code and LAN link instead of the LAN address, e.g. an ngrok or Tailscale hostname")
+	flag.IntVar(&cfg.BindRetries, "bind-retries", 3, "retry binding a port that's in use this many `times`, waiting 100ms and doubling, before picking a free one")
+	flag.Func("app", "serve the embedded build in dist/`name` instead of dist itself (repeatable, switch in the tray)", func(v string) error {
+		cfg.Apps = append(cfg.Apps, v)
+		return nil
+	})
+	flag.Func("dir", "serve this `[name=]path` from disk instead of the embedded build (repeatable, switch in the tray)", func(v string) error {
+		cfg.Dirs = append(cfg.Dirs, parseServedDir(v))
+		return nil
+	})
+	flag.Func("archive", "serve this `[name=]file` (.zip, .tar.gz, or .tar) without unpacking it (repeatable, like -dir)", func(v string) error {
+		cfg.Archives = append(cfg.Archives, parseServedDir(v))
+		return nil
+	})
+	flag.BoolVar(&cfg.TLS, "tls", false, "serve HTTPS with a self-signed certificate")
+	flag.StringVar(&cfg.TLSCertFile, "tls-cert", "", "serve HTTPS with the certificate in this PEM `file`, e.g. from mkcert, instead of a self-signed one (needs -tls-key)")
+	flag.StringVar(&cfg.TLSKeyFile, "tls-key", "", "the private key `file` for -tls-cert")
+	flag.IntVar(&cfg.TLSPort, "tls-port", 0, "also serve HTTPS on this `port`, keeping plain HTTP on -port")
+	flag.StringVar(&cfg.Auth, "auth", "", "require HTTP basic auth as `user:pass`")
+	flag.Func("allow", "only serve clients in these comma-separated `CIDRs` (loopback is always allowed)", func(v string) error {
+		cfg.AllowCIDRs = append(cfg.AllowCIDRs, strings.Split(v, ",")...)
+		return nil
+	})
+	flag.Func("header", "add this `\"Name: Value\"` header to every response (repeatable)", func(v string) error {
+		h, err := parseHeader(v)
+		if err != nil {
+			return err
+		}
+		cfg.Headers = append(cfg.Headers, h)
+		return nil
+	})
+	flag.StringVar(&cfg.CSP, "csp", "off", "send a Content-Security-Policy: the strict or relaxed `preset` for a Vite/React build, off, or a policy of your own (a -header for it wins)")
+	flag.Func("runtime-config", "expose `key=value` to the app as window.__CONFIG__.key, injected into its HTML at <!--CONFIG--> or before </head>; values can use {host}, {origin}, {lanIP}, and {lanURL} (repeatable)", func(v string) error {
+		s, err := parseRuntimeSetting(v)
+		if err != nil {
+			return err
+		}
+		cfg.RuntimeConfig = append(cfg.RuntimeConfig, s)
+		return nil
+	})
+	flag.Func("proxy", "forward requests under a path to a backend, as `/prefix=http://host:port` (repeatable)", func(v string) error {
+		p, err := parseProxy(v)
+		if err != nil {
+			return err
+		}
+		for _, other := range cfg.Proxies {
+			if other.Prefix == p.Prefix {
+				return fmt.Errorf("%s is already proxied to %s", p.Prefix, other.Target)
+			}
+		}
+		cfg.Proxies = append(cfg.Proxies, p)
+		return nil
+	})
+	flag.Func("redirect", "redirect requests for a path elsewhere, as `/from=/to`; /from/* matches everything under it, and /to/* keeps the rest of the path (repeatable)", func(v string) error {
+		rule, err := parseRedirect(v)
+		if err != nil {
+			return err
+		}
+		cfg.Redirects = append(cfg.Redirects, rule)
+		return nil
+	})
+	flag.IntVar(&cfg.RedirectStatus, "redirect-status", http.StatusFound, "answer -redirect matches with this `status`: 301 or 308 for a permanent move, 302 or 307 otherwise")
+	flag.StringVar(&cfg.CORS, "cors", "", "allow cross-origin requests from these comma-separated `origins`, or * for any")
+	flag.Int64Var(&cfg.MaxBody, "max-body", 1<<20, "reject request bodies over this many `bytes` with 413 (0 for no limit; -proxy paths are exempt)")
+	flag.Float64Var(&cfg.Rate, "rate", 0, "limit each non-loopback client to this many `requests` per second (0 for no limit)")
+	flag.BoolVar(&cfg.ServerTiming, "server-timing", false, "add a Server-Timing header with how long each response took to start, shown in the browser's Network tab")
+	flag.Int64Var(&cfg.Throttle, "throttle", 0, "slow responses to this many `bytes` per second on each connection, e.g. 50000 for a slow 3G phone (0 for no limit)")
+	flag.IntVar(&cfg.MaxConns, "max-conns", 0, "handle at most this many `requests` at once, queueing the rest briefly and then answering 503 (0 for no limit)")
+	flag.IntVar(&cfg.Burst, "burst", 0, "allow bursts of this many `requests` above -rate (default: one second's worth)")
+	flag.BoolVar(&cfg.Gzip, "gzip", true, "gzip-compress text, JavaScript, JSON, and SVG responses")
+	flag.BoolVar(&cfg.Precompressed, "precompressed", true, "serve a file's .br or .gz sibling, when one exists, to browsers that accept it")
+	flag.BoolVar(&cfg.Brotli, "brotli", true, "brotli-compress the same responses for browsers that accept it, in preference to gzip")
+	flag.BoolVar(&cfg.Notify, "notify", true, "show a desktop notification when a new device connects")
+	flag.BoolVar(&cfg.NoTray, "no-tray", false, "run without the system tray, e.g. on a headless server")
+	flag.BoolVar(&cfg.Reopen, "reopen", true, "open a new tab on every Open App click; with -reopen=false, only when no tab on this computer has the app open")
+	flag.BoolVar(&cfg.NoQuit, "no-quit", false, "hide the tray's Quit item, e.g. for a kiosk (stop with a signal or -shutdown-token)")
+	flag.BoolVar(&cfg.NoCopy, "no-copy", false, "hide the tray items that copy URLs and server info")
+	flag.StringVar(&cfg.DeviceCommand, "device-command", "adb shell am start -a android.intent.action.VIEW -d {url}", "`command` the tray's Copy Device Command copies, with {url} replaced by the LAN URL, e.g. for scrcpy or xcrun simctl (empty hides the item)")
+	flag.StringVar(&cfg.LinkLabel, "link-label", "My App", "link `text` for the tray's Copy as Markdown Link")
+	flag.BoolVar(&cfg.NoQR, "no-qr", false, "hide the tray's QR code items")
+	flag.BoolVar(&cfg.QRTerminal, "qr-terminal", false, "print the LAN URL QR code to the terminal on startup")
+	flag.StringVar(&cfg.QRLevel, "qr-level", "medium", "QR error correction: low, medium, high, or highest")
+	flag.IntVar(&cfg.QRSize, "qr-size", 256, "QR image size in `pixels`")
+	flag.StringVar(&cfg.QRFormat, "qr-format", "png", "QR image format for /qr and saved files: png, or svg to scale for print and documents")
+	flag.StringVar(&cfg.WiFiSSID, "wifi-ssid", "", "also show a QR code that joins this Wi-Fi `network`, ahead of the URL's, e.g. for events")
+	flag.StringVar(&cfg.WiFiPassword, "wifi-password", "", "password for -wifi-ssid")
+	flag.StringVar(&cfg.WiFiSecurity, "wifi-security", "WPA", "security for -wifi-ssid: WPA, WEP, or nopass")
+	flag.BoolVar(&cfg.PrintQR, "print-qr", false, "print the LAN URL QR code for -port and exit without serving (with -qr-out, also save the image)")
+	flag.BoolVar(&cfg.QRKeep, "qr-keep", false, "keep the QR code saved to the temp directory after quitting")
+	flag.StringVar(&cfg.QROut, "qr-out", "", "save QR code images to this `path` (default: a temp file, removed on quit unless -qr-keep)")
+	flag.BoolVar(&cfg.AssetVersions, "asset-versions", false, "rewrite src and href references in the embedded index.html to ?v=<content hash> URLs, and cache those forever")
+	flag.StringVar(&cfg.Index, "index", "index.html", "serve this `file` for folder requests and client-side routes")
+	flag.Func("index-lang", "serve this `lang=file` as the root page to browsers preferring lang, e.g. fr=index.fr.html (repeatable; others get -index)", func(v string) error {
+		lang, file, err := parseLangIndex(v)
+		if err != nil {
+			return err
+		}
+		if cfg.LangIndexes == nil {
+			cfg.LangIndexes = make(map[string]string)
+		}
+		cfg.LangIndexes[lang] = file
+		return nil
+	})
+	flag.BoolVar(&cfg.Listing, "listing", false, "list the files in -dir folders that have no index.html, instead of the single-page app fallback")
+	flag.BoolVar(&cfg.NoSymlinks, "no-symlinks", false, "answer 403 for paths in a -dir folder whose symlinks lead outside it, so sharing a folder can't expose other files")
+	flag.BoolVar(&cfg.NoDirRedirect, "no-dir-redirect", false, "treat folders as missing, so the single-page app fallback gets /docs and /docs/ instead of a redirect or the folder's index.html")
+	flag.BoolVar(&cfg.StrictPaths, "strict-paths", false, "serve only files at exactly the requested path, with no single-page app fallback, folder index.html, or trailing-slash redirect, e.g. for a documentation site")
+	flag.BoolVar(&cfg.LiveReload, "live-reload", true, "reload open pages when files in a -dir folder change")
+	flag.BoolVar(&cfg.H2C, "h2c", false, "also speak HTTP/2 without TLS, for tools like curl --http2-prior-knowledge (browsers only use HTTP/2 over TLS)")
+	flag.BoolVar(&cfg.RestrictMethods, "restrict-methods", true, "answer methods other than GET, HEAD, and OPTIONS with 405, except under -proxy prefixes and POST /shutdown")
+	flag.StringVar(&cfg.Uploads, "uploads", "", "save files sent from the form at /upload into this `folder`, e.g. from a phone (up to -max-body each request)")
+	flag.StringVar(&cfg.OnConnect, "on-connect", "", "run this `command` (split on spaces, no shell) when a new device connects, with its IP as the last argument and in $DAPPTOON_CLIENT_IP; output goes to the log")
+	flag.StringVar(&cfg.Exec, "exec", "", "start this `command` (split on spaces, no shell), e.g. a backend, once the server is listening, logging its output and stopping it on quit")
+	flag.BoolVar(&cfg.Events, "events", false, "stream messages to pages at /events (server-sent events), sent from this computer with POST /broadcast, e.g. to drive a demo")
+	flag.BoolVar(&cfg.Info, "info", false, "describe how the app is being served, its LAN IP, port, URLs, and OS, as JSON at /__info")
+	flag.BoolVar(&cfg.WSEcho, "ws-echo", false, "serve a WebSocket at /ws-echo that echoes and logs every message, to test an app's client against")
+	flag.BoolVar(&cfg.WatchRestart, "watch-restart", false, "restart the server when files in a -dir folder change, like the tray's Restart Server (open pages reload afterwards with -live-reload)")
+	flag.BoolVar(&cfg.Open, "open", false, "open the app in the browser once the server answers /healthz")
+	flag.StringVar(&cfg.Title, "title", "", "tray `title`, shown next to the icon on macOS and some Linux desktops (default: \"React Server\")")
+	flag.StringVar(&cfg.Tooltip, "tooltip", "", "tray tooltip `text`, ahead of the serving status once it's known (default: \"Serving your React app\")")
+	flag.StringVar(&cfg.Icon, "icon", "", "use this PNG `file` as the tray icon, favicon fallback, and notification icon instead of the embedded one")
+	flag.StringVar(&cfg.Lang, "lang", "", "tray menu `language`: en, de, es, or fr (default: the system locale)")
+	flag.BoolVar(&cfg.CheckUpdate, "check-update", false, "look up the latest release on GitHub at startup and say if it's newer than this binary (nothing is downloaded)")
+	flag.BoolVar(&cfg.LowPower, "low-power", false, "save battery: check for LAN IP changes only when the URL is needed instead of polling, and refresh the tray and firewall check far less often")
+	flag.BoolVar(&cfg.Strict, "strict", false, "exit with code 3 when the binary has no embedded build and no -dir or -archive is given, instead of serving a placeholder page")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "check the settings and print what would be served and where, then exit without serving")
+	flag.BoolVar(&cfg.Verbose, "verbose", false, "log every request, from the start (the tray's Verbose Logging item toggles it while serving)")
+	flag.BoolVar(&cfg.NoColor, "no-color", false, "print plain ASCII to the console, as is automatic when output isn't a terminal or NO_COLOR is set")
+	flag.StringVar(&cfg.LogFormat, "log-format", "text", "log as human-readable `text`, structured json, or clf: request lines in Apache's Combined Log Format, for tools like GoAccess (turns on -verbose)")
+	flag.StringVar(&cfg.LogFile, "log-file", "", "write logs to this `file` instead of the console, rotating it by size")
+	flag.IntVar(&cfg.LogMaxSize, "log-max-size", 10, "rotate the -log-file once it reaches this many `megabytes`, keeping the last 3")
+	flag.StringVar(&cfg.BasePath, "base-path", "/", "serve the app under this `path` prefix, e.g. /app/")
+	flag.StringVar(&cfg.MDNSName, "mdns-name", "dapptoon", "advertise the app over mDNS as `name`.local (empty to disable)")
+	flag.StringVar(&cfg.MDNSInterface, "mdns-interface", "", "advertise mDNS only on this network `interface`, e.g. en0, with its addresses (default: the LAN IP's interface)")
+	flag.StringVar(&cfg.CachePattern, "cache-pattern", server.DefaultHashPattern, "`regexp` matching content-hashed asset paths to cache forever")
+	flag.BoolVar(&cfg.Unlisted, "unlisted", false, "serve everything under a random /s/<token>/ path that only the printed link and QR code contain, so others on the LAN can't stumble onto it (other paths 404)")
+	flag.StringVar(&cfg.Token, "token", "", "use this `token` in the -unlisted path instead of a new random one each run (implies -unlisted; letters, digits, - and _)")
+	flag.StringVar(&cfg.ShutdownToken, "shutdown-token", "", "enable POST /shutdown for requests with this `token` in an X-Shutdown-Token header")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 5*time.Second, "how long to let open requests finish on quit or restart before closing them")
+	flag.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", 10*time.Second, "how long a client may take to send request headers")
+	flag.DurationVar(&cfg.ReadTimeout, "read-timeout", 30*time.Second, "how long a client may take to send a whole request (0 for no limit)")
+	flag.DurationVar(&cfg.WriteTimeout, "write-timeout", 60*time.Second, "how long a response may take to send, e.g. to a slow phone (0 for no limit)")
+	flag.DurationVar(&cfg.IdleTimeout, "idle-timeout", 2*time.Minute, "how long to keep idle keep-alive connections open")
+	configPath := flag.String("config", "", "read settings from this JSON `file` (or $DAPPTOON_CONFIG); command-line flags take precedence")
+	flag.Parse()
+
+	envVars, err := loadSettings(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.LogFile != "" {
+		if cfg.LogMaxSize < 1 {
+			fmt.Fprintf(os.Stderr, "invalid -log-max-size %d: must be at least 1\n", cfg.LogMaxSize)
+			os.Exit(2)
+		}
+		setLogFile(cfg.LogFile, cfg.LogMaxSize)
+	}
+	if err := setLogFormat(cfg.LogFormat); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -log-format:", err)
+		os.Exit(2)
+	}
+	setPlainOutput(cfg.NoColor)
+	// Requests are all a clf log is for
+	verboseLogs.Store(cfg.Verbose || cfg.LogFormat == "clf")
+	if len(envVars) > 0 || *configPath != "" {
+		sources := []string{"defaults"}
+		if len(envVars) > 0 {
+			sources = append(sources, "environment ("+strings.Join(envVars, ", ")+")")
+		}
+		if *configPath != "" {
+			sources = append(sources, *configPath)
+		}
+		log.Printf("Settings: %s < command-line flags", strings.Join(sources, " < "))
+	}
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		fmt.Fprintf(os.Stderr, "invalid port %d: must be between 1 and 65535\n", cfg.Port)
+		os.Exit(2)
+	}
+	if cfg.TLSPort < 0 || cfg.TLSPort > 65535 || cfg.TLSPort == cfg.Port {
+		fmt.Fprintf(os.Stderr, "invalid -tls-port %d: must be between 1 and 65535 and differ from -port\n", cfg.TLSPort)
+		os.Exit(2)
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		fmt.Fprintln(os.Stderr, "invalid -tls-cert/-tls-key: give both or neither")
+		os.Exit(2)
+	}
+	if cfg.TLSCertFile != "" {
+		c, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -tls-cert/-tls-key:", err)
+			os.Exit(2)
+		}
+		tlsCert = &c
+		// A certificate of your own means HTTPS, on -port unless -tls-port says otherwise
+		cfg.TLS = true
+	}
+	if cfg.UnixSocket != "" && cfg.TLSPort != 0 {
+		fmt.Fprintln(os.Stderr, "invalid -tls-port: a Unix socket can't also serve a second port")
+		os.Exit(2)
+	}
+	if cfg.Bind != "" && cfg.Bind != "localhost" && net.ParseIP(cfg.Bind) == nil {
+		fmt.Fprintf(os.Stderr, "invalid -bind %q: expected an IP address or localhost\n", cfg.Bind)
+		os.Exit(2)
+	}
+	if policy, err := cspPolicy(cfg.CSP); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -csp:", err)
+		os.Exit(2)
+	} else if policy == cspPresets["strict"] && ((cfg.LiveReload && len(cfg.Dirs) > 0) || !cfg.Reopen || len(cfg.RuntimeConfig) > 0) {
+		log.Println("-csp strict blocks the inline scripts live reload, -reopen=false, and -runtime-config add to pages; use -csp relaxed to keep them working")
+	}
+	if _, err := net.InterfaceByName(cfg.MDNSInterface); cfg.MDNSInterface != "" && err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -mdns-interface %q: %v\n", cfg.MDNSInterface, err)
+		os.Exit(2)
+	}
+	// A blank hook is no hook, so the checks for one can compare to ""
+	cfg.OnConnect = strings.TrimSpace(cfg.OnConnect)
+	if fields := strings.Fields(cfg.OnConnect); len(fields) > 0 {
+		if _, err := exec.LookPath(fields[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -on-connect: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if fields := strings.Fields(cfg.Exec); len(fields) > 0 {
+		if _, err := exec.LookPath(fields[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -exec: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if cfg.DeviceCommand != "" && !strings.Contains(cfg.DeviceCommand, "{url}") {
+		fmt.Fprintln(os.Stderr, "invalid -device-command: expected {url} where the LAN URL goes")
+		os.Exit(2)
+	}
+	if cfg.DualStack && (cfg.Bind != "" || cfg.UnixSocket != "") {
+		fmt.Fprintln(os.Stderr, "invalid -dual-stack: -bind and -unix-socket already pick one address")
+		os.Exit(2)
+	}
+	if u, err := neturl.Parse(cfg.PublicURL); cfg.PublicURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		fmt.Fprintf(os.Stderr, "invalid -public-url %q: expected an http or https URL\n", cfg.PublicURL)
+		os.Exit(2)
+	}
+	switch cfg.RedirectStatus {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -redirect-status %d: choose 301, 302, 307, or 308\n", cfg.RedirectStatus)
+		os.Exit(2)
+	}
+	if cfg.Throttle < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -throttle %d: must be 0 or more\n", cfg.Throttle)
+		os.Exit(2)
+	}
+	if cfg.MaxConns < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -max-conns %d: must be 0 or more\n", cfg.MaxConns)
+		os.Exit(2)
+	}
+	if info, err := os.Stat(cfg.Uploads); cfg.Uploads != "" && (err != nil || !info.IsDir()) {
+		fmt.Fprintf(os.Stderr, "invalid -uploads %q: must be an existing folder\n", cfg.Uploads)
+		os.Exit(2)
+	}
+	if cfg.LANIP != "" && net.ParseIP(cfg.LANIP) == nil {
+		fmt.Fprintf(os.Stderr, "invalid -lan-ip %q: expected an IP address\n", cfg.LANIP)
+		os.Exit(2)
+	}
+	if cfg.LANIP != "" && boundLANIP() != nil {
+		fmt.Fprintln(os.Stderr, "invalid -lan-ip: -bind already limits the server to one address")
+		os.Exit(2)
+	}
+	chooseLANIP(cfg.LANIP)
+	if cfg.StrictPaths && (cfg.Listing || cfg.NoDirRedirect || len(cfg.LangIndexes) > 0) {
+		fmt.Fprintln(os.Stderr, "invalid -strict-paths: it replaces the single-page app fallback and folder pages, which -listing, -no-dir-redirect, and -index-lang change")
+		os.Exit(2)
+	}
+	// The file server redirects /index.html to /, where -index is served
+	for lang, file := range cfg.LangIndexes {
+		if file == "index.html" && cfg.Index != "index.html" {
+			fmt.Fprintf(os.Stderr, "invalid -index-lang %s=%s: index.html can only be the page for / when it's the -index\n", lang, file)
+			os.Exit(2)
+		}
+	}
+	if cfg.NoDirRedirect && cfg.Listing {
+		fmt.Fprintln(os.Stderr, "invalid -no-dir-redirect: -listing needs folders to list")
+		os.Exit(2)
+	}
+	if security, ok := wifiSecurities[strings.ToLower(cfg.WiFiSecurity)]; !ok {
+		fmt.Fprintf(os.Stderr, "invalid -wifi-security %q: choose WPA, WEP, or nopass\n", cfg.WiFiSecurity)
+		os.Exit(2)
+	} else if cfg.WiFiSSID != "" && security != "nopass" && cfg.WiFiPassword == "" {
+		fmt.Fprintln(os.Stderr, "invalid -wifi-ssid: needs -wifi-password, or -wifi-security nopass for an open network")
+		os.Exit(2)
+	}
+	if cfg.Auth != "" && !strings.Contains(cfg.Auth, ":") {
+		fmt.Fprintln(os.Stderr, "invalid -auth: expected user:pass")
+		os.Exit(2)
+	}
+	if cfg.Index == "" || strings.ContainsAny(cfg.Index, `/\`) {
+		fmt.Fprintf(os.Stderr, "invalid -index %q: expected a file name like app.html\n", cfg.Index)
+		os.Exit(2)
+	}
+	if !cfg.NoTray && !desktopAvailable() {
+		log.Println("No desktop session (DISPLAY and WAYLAND_DISPLAY are unset), running without the tray")
+		cfg.NoTray = true
+	}
+	if cfg.NoTray {
+		notifyEnabled = false
+	} else if cfg.NoQuit {
+		log.Println("The tray has no Quit item (-no-quit); stop with Ctrl-C, a signal, or POST /shutdown")
+	}
+	setQROptions(cfg.QRLevel, cfg.QRSize, cfg.QRFormat)
+	setLang(cfg.Lang)
+	if cfg.Icon != "" {
+		data, err := loadIcon(cfg.Icon)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -icon:", err)
+			os.Exit(2)
+		}
+		iconData = data
+	}
+
+	// Normalize to "" or "/app" so it can be joined with paths
+	cfg.BasePath = strings.TrimSuffix("/"+strings.Trim(cfg.BasePath, "/"), "/")
+	if cfg.Token != "" && !validShareToken(cfg.Token) {
+		fmt.Fprintf(os.Stderr, "invalid -token %q: expected up to 64 letters, digits, - and _\n", cfg.Token)
+		os.Exit(2)
+	}
+	if cfg.Unlisted || cfg.Token != "" {
+		cfg.Token = cmp.Or(cfg.Token, newShareToken())
+		// Every URL that's printed, copied, or encoded is built on the base path
+		cfg.BasePath += "/s/" + cfg.Token
+	}
 
-	fmt.Println("Serving at:", lanURL)
+	if cfg.PrintQR {
+		printQROnly()
+		return
+	}
+	if cfg.DryRun {
+		os.Exit(dryRun())
+	}
 
-	startServer()
+	registerMIMETypes()
+	log.Printf("Dapptoon %s, embedded build: %s", appVersion(), embeddedBuildVersion())
 
+	sites, err = loadSites(cfg.Apps, cfg.Dirs, cfg.Archives)
+	if errors.Is(err, errNoBuild) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitNoBuild)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	activeSite.Store(sites[0])
+	if len(cfg.Dirs) > 0 && !cfg.NoTray {
+		shipped = shippedSite()
+	}
+
+	if (cfg.LiveReload || cfg.WatchRestart) && len(cfg.Dirs) > 0 {
+		var paths []string
+		for _, d := range cfg.Dirs {
+			paths = append(paths, d.Path)
+		}
+		// One watcher serves both: a restart ends the live-reload streams,
+		// and pages reload once they reconnect to the new server
+		settle, onChange := 200*time.Millisecond, reloads.broadcast
+		if cfg.WatchRestart {
+			settle, onChange = time.Second, restartOnChange
+		}
+		if err := watchDirs(paths, settle, onChange); err != nil {
+			warn("Live reload disabled, failed to watch folders: " + err.Error())
+		}
+	}
+
+	// A socket has no address other devices could use
+	var ip, ip6 string
+	if cfg.UnixSocket == "" {
+		ip, ip6 = lanIPs()
+		warnIfLocalOnly()
+	}
+
+	urlScheme = "http"
+	if tlsCert != nil {
+		if leaf := tlsCert.Leaf; leaf != nil && ip != "" && leaf.VerifyHostname(ip) != nil {
+			warnf("WARNING: the -tls-cert doesn't cover the LAN IP %s, so other devices will see a certificate warning", ip)
+		}
+		urlScheme = "https"
+	} else if cfg.TLS || cfg.TLSPort != 0 {
+		hosts := []string{ip, ip6}
+		if cfg.MDNSName != "" {
+			hosts = append(hosts, cfg.MDNSName+".local")
+		}
+		c, err := selfSignedCert(hosts...)
+		if err != nil {
+			log.Fatalf("Failed to generate TLS certificate: %v", err)
+		}
+		tlsCert = &c
+		urlScheme = "https"
+	}
+
+	servers, bound, boundTLS, err := startServer(cfg, tlsCert)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srvs, boundPort, boundTLSPort = servers, bound, boundTLS
+
+	// With both protocols, HTTPS is the primary URL so the QR code opens a
+	// secure context
+	urlPort = bound
+	if boundTLS != 0 {
+		urlPort, httpPort = boundTLS, bound
+	}
+	setLANIPs(ip, ip6)
+	if cfg.UnixSocket == "" {
+		localURL = appURL(hostURL(urlScheme, localHost(), urlPort))
+	}
+
+	url, _ := currentLANURLs()
+	printBanner(bound, boundTLS)
+	if cfg.UnixSocket != "" {
+		say("Serving at: unix:" + cfg.UnixSocket)
+	} else if url != "" {
+		say("Serving at:", url)
+	} else {
+		say("Serving at:", localURL, "(no LAN IP available; other devices can't connect until one appears)")
+	}
+	if url6 := dualStackURL6(); url6 != "" {
+		say("Also at:", url6)
+	}
+	if httpURL := currentHTTPURL(); httpURL != "" {
+		say("Also at:", httpURL)
+	}
+	if cfg.PublicURL != "" {
+		say("QR code and LAN link:", cfg.PublicURL)
+	}
+	logEvent("server_start", "", "url", url, "local_url", localURL, "port", urlPort, "tls", urlScheme == "https")
+
+	if cfg.UnixSocket == "" {
+		if cfg.MDNSName != "" && startMDNS(urlPort, ip, ip6) {
+			say("Also at:", appURL(hostURL(urlScheme, cfg.MDNSName+".local", urlPort)))
+		}
+		// With -low-power shareURL checks the IP when it's needed instead
+		if !cfg.LowPower {
+			go watchLANIP(5 * time.Second)
+		}
+		go watchFirewall(pollEvery(time.Minute))
+		go checkLAN()
+	}
+	if cfg.CheckUpdate {
+		go checkUpdate()
+	}
+
+	// Sites, archives, and the TLS certificate are all ready before the
+	// listener binds, so once /healthz answers the real app is being served
+	// and the browser never sees a refused connection or a half-loaded site
+	if cfg.Open && localURL != "" {
+		go func() {
+			if err := waitUntilServing(strings.TrimSuffix(localURL, "/")+"/healthz", 5*time.Second); err != nil {
+				log.Println("Not opening browser:", err)
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), browserWait)
+			defer cancel()
+			if err := openBrowser(ctx, localURL); err != nil {
+				log.Println("Failed to open browser:", err)
+			}
+		}()
+	}
+
+	if strings.TrimSpace(cfg.Exec) != "" {
+		go func() {
+			if localURL != "" {
+				if err := waitUntilServing(strings.TrimSuffix(localURL, "/")+"/healthz", 5*time.Second); err != nil {
+					log.Println("Starting -exec anyway:", err)
+				}
+			}
+			startCompanion(cfg.Exec)
+		}()
+	}
+
+	// Headless runs always print the QR since there is no other way to get it.
+	// As with -open, it waits until the server answers, so a phone that scans
+	// it right away can't beat the listener
+	if url := shareURL(); (cfg.QRTerminal || cfg.NoTray) && url != "" {
+		if localURL != "" {
+			if err := waitUntilServing(strings.TrimSuffix(localURL, "/")+"/healthz", 5*time.Second); err != nil {
+				log.Println("Printing the QR code anyway:", err)
+			}
+		}
+		printWiFiQR()
+		printQR(url)
+	}
+
+	// Stop cleanly on Ctrl-C or a process manager's SIGTERM, leaving neither
+	// the listener nor the mDNS registration behind
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	if cfg.NoTray {
+		select {
+		case <-sig:
+		case <-quitRequested:
+		}
+		shutdown()
+		return
+	}
+
+	go func() {
+		select {
+		case <-sig:
+		case <-quitRequested:
+		}
+		shutdown()
+		systray.Quit()
+	}()
+	go watchTrayReady(10 * time.Second)
 	systray.Run(onReady, func() {})
 }
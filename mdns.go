@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"github.com/grandcat/zeroconf"
+)
+
+var mdnsServer *zeroconf.Server
+
+// advertiseMDNS announces name.local and an _http._tcp service on the LAN,
+// so devices can browse to the app by hostname instead of IP.
+func advertiseMDNS(name string, port int, ips []string, ifaces []net.Interface) error {
+	var txt []string
+	// Anyone browsing the LAN sees the record, so it mustn't carry the
+	// -unlisted token
+	if cfg.Token == "" {
+		txt = []string{"path=" + cfg.BasePath + "/"}
+	}
+	s, err := zeroconf.RegisterProxy("Dapptoon", "_http._tcp", "local.", port, name, ips, txt, ifaces)
+	if err != nil {
+		return err
+	}
+	mdnsServer = s
+	return nil
+}
+
+// startMDNS advertises cfg.MDNSName for whichever of the given IPs are set,
+// logging rather than failing since the app works without it. It answers
+// only on the interface the IPs belong to, or the -mdns-interface one with
+// that interface's own addresses, so on a machine with Docker or a VPN the
+// name doesn't resolve to an address other devices can't reach.
+func startMDNS(port int, ips ...string) bool {
+	var valid []string
+	for _, ip := range ips {
+		if ip != "" {
+			valid = append(valid, ip)
+		}
+	}
+	var ifaces []net.Interface
+	if cfg.MDNSInterface != "" {
+		iface, err := net.InterfaceByName(cfg.MDNSInterface)
+		if err != nil {
+			warn("Failed to advertise over mDNS: " + err.Error())
+			return false
+		}
+		ifaces = []net.Interface{*iface}
+		valid = ifaceIPs(cfg.MDNSInterface)
+	} else if iface := ifaceWithIP(valid); iface != nil {
+		ifaces = []net.Interface{*iface}
+	}
+	if len(valid) == 0 {
+		return false
+	}
+	if err := advertiseMDNS(cfg.MDNSName, port, valid, ifaces); err != nil {
+		warn("Failed to advertise over mDNS: " + err.Error())
+		return false
+	}
+	where := "all interfaces"
+	if len(ifaces) > 0 {
+		where = ifaces[0].Name
+	}
+	log.Printf("mDNS: advertising %s.local as %s on %s", cfg.MDNSName, strings.Join(valid, ", "), where)
+	return true
+}
+
+// ifaceWithIP returns the interface that has the first of ips, or nil.
+func ifaceWithIP(ips []string) *net.Interface {
+	if len(ips) == 0 {
+		return nil
+	}
+	for _, c := range append(lanCandidates(false), lanCandidates(true)...) {
+		if c.ip.String() == ips[0] {
+			iface, err := net.InterfaceByName(c.iface)
+			if err == nil {
+				return iface
+			}
+		}
+	}
+	return nil
+}
+
+// ifaceIPs returns the best IPv4 and IPv6 LAN addresses of the named
+// interface.
+func ifaceIPs(name string) []string {
+	var ips []string
+	for _, v6 := range []bool{false, true} {
+		for _, c := range lanCandidates(v6) {
+			if c.iface == name {
+				ips = append(ips, c.ip.String())
+				break
+			}
+		}
+	}
+	return ips
+}
+
+// stopMDNS withdraws the advertisement so the name stops resolving.
+func stopMDNS() {
+	if mdnsServer != nil {
+		mdnsServer.Shutdown()
+		mdnsServer = nil
+		log.Println("mDNS advertisement stopped")
+	}
+}
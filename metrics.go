@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// startTime is when the app started, for uptime.
+var startTime = time.Now()
+
+// metrics are counters over the whole session, exposed at /metrics.
+var metrics struct {
+	requests atomic.Int64
+	// byClass counts responses by status class, 1xx to 5xx
+	byClass [5]atomic.Int64
+	bytes   atomic.Int64
+}
+
+// latencyBuckets are the upper bounds of the response-time histogram:
+// 0.5ms doubling up to about two minutes, with everything slower in a last
+// bucket. Fixed buckets keep memory constant however long the session.
+var latencyBuckets = func() []time.Duration {
+	bounds := make([]time.Duration, 19)
+	for i := range bounds {
+		bounds[i] = 500 * time.Microsecond << i
+	}
+	return bounds
+}()
+
+// latencies counts responses per latencyBuckets entry, plus one over them,
+// and latencySum totals their time.
+var (
+	latencies  [20]atomic.Int64
+	latencySum atomic.Int64
+)
+
+// recordLatency adds d to the histogram.
+func recordLatency(d time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	latencies[i].Add(1)
+	latencySum.Add(int64(d))
+}
+
+// latencyPercentile estimates the q quantile (0 to 1) of response times,
+// interpolating within the bucket it falls in, or returns 0 before any
+// responses.
+func latencyPercentile(q float64) time.Duration {
+	var counts [len(latencies)]int64
+	var total int64
+	for i := range latencies {
+		counts[i] = latencies[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var seen int64
+	for i, n := range counts {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+		// The last bucket has no upper bound, so report its lower one
+		if i == len(latencyBuckets) {
+			return latencyBuckets[i-1]
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = latencyBuckets[i-1]
+		}
+		frac := (rank - float64(seen)) / float64(n)
+		return lower + time.Duration(frac*float64(latencyBuckets[i]-lower))
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+// deviceIdle is how long a device goes unseen before its byte count is
+// dropped, which keeps the table small on a busy network.
+const deviceIdle = 30 * time.Minute
+
+// deviceUsage is how much one client has downloaded.
+type deviceUsage struct {
+	ip    string
+	bytes int64
+	seen  time.Time
+}
+
+// deviceBytes tallies response bytes per client IP, to spot a device that
+// keeps downloading the same assets.
+var deviceBytes = struct {
+	sync.Mutex
+	byIP      map[string]*deviceUsage
+	lastPrune time.Time
+}{byIP: make(map[string]*deviceUsage)}
+
+// addDeviceBytes adds n bytes to ip's tally, pruning idle devices at most
+// once a minute.
+func addDeviceBytes(ip string, n int64) {
+	now := time.Now()
+	deviceBytes.Lock()
+	defer deviceBytes.Unlock()
+	d := deviceBytes.byIP[ip]
+	if d == nil {
+		d = &deviceUsage{ip: ip}
+		deviceBytes.byIP[ip] = d
+	}
+	d.bytes += n
+	d.seen = now
+
+	if now.Sub(deviceBytes.lastPrune) < time.Minute {
+		return
+	}
+	deviceBytes.lastPrune = now
+	for ip, d := range deviceBytes.byIP {
+		if now.Sub(d.seen) > deviceIdle {
+			delete(deviceBytes.byIP, ip)
+		}
+	}
+}
+
+// topDevices returns up to n devices that downloaded the most, or all of
+// them for n <= 0.
+func topDevices(n int) []deviceUsage {
+	deviceBytes.Lock()
+	devices := make([]deviceUsage, 0, len(deviceBytes.byIP))
+	for _, d := range deviceBytes.byIP {
+		devices = append(devices, *d)
+	}
+	deviceBytes.Unlock()
+
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].bytes != devices[j].bytes {
+			return devices[i].bytes > devices[j].bytes
+		}
+		return devices[i].ip < devices[j].ip
+	})
+	if n > 0 && len(devices) > n {
+		devices = devices[:n]
+	}
+	return devices
+}
+
+// countMetrics records each response in metrics, deviceBytes, and the
+// latency histogram. Bytes are counted as sent, after compression. Event
+// streams and WebSockets last as long as a tab, so their time isn't.
+func countMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		if r.Header.Get("Accept") != "text/event-stream" && r.Header.Get("Upgrade") == "" {
+			recordLatency(time.Since(start))
+		}
+
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.requests.Add(1)
+		if class := status/100 - 1; class >= 0 && class < len(metrics.byClass) {
+			metrics.byClass[class].Add(1)
+		}
+		metrics.bytes.Add(rw.bytes)
+		addDeviceBytes(clientIP(r).String(), rw.bytes)
+	})
+}
+
+// serveMetrics writes the counters in the Prometheus text format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	fmt.Fprintln(w, "# HELP dapptoon_requests_total Requests served.")
+	fmt.Fprintln(w, "# TYPE dapptoon_requests_total counter")
+	fmt.Fprintf(w, "dapptoon_requests_total %d\n", metrics.requests.Load())
+	fmt.Fprintln(w, "# HELP dapptoon_responses_total Responses by status code class.")
+	fmt.Fprintln(w, "# TYPE dapptoon_responses_total counter")
+	for i := range metrics.byClass {
+		fmt.Fprintf(w, "dapptoon_responses_total{code=\"%dxx\"} %d\n", i+1, metrics.byClass[i].Load())
+	}
+	fmt.Fprintln(w, "# HELP dapptoon_response_bytes_total Response body bytes written.")
+	fmt.Fprintln(w, "# TYPE dapptoon_response_bytes_total counter")
+	fmt.Fprintf(w, "dapptoon_response_bytes_total %d\n", metrics.bytes.Load())
+	fmt.Fprintln(w, "# HELP dapptoon_device_response_bytes_total Response body bytes written per client seen in the last 30 minutes.")
+	fmt.Fprintln(w, "# TYPE dapptoon_device_response_bytes_total counter")
+	for _, d := range topDevices(0) {
+		fmt.Fprintf(w, "dapptoon_device_response_bytes_total{device=%q} %d\n", d.ip, d.bytes)
+	}
+	fmt.Fprintln(w, "# HELP dapptoon_request_duration_seconds Time to serve requests.")
+	fmt.Fprintln(w, "# TYPE dapptoon_request_duration_seconds histogram")
+	var cumulative int64
+	for i, bound := range latencyBuckets {
+		cumulative += latencies[i].Load()
+		fmt.Fprintf(w, "dapptoon_request_duration_seconds_bucket{le=\"%g\"} %d\n", bound.Seconds(), cumulative)
+	}
+	cumulative += latencies[len(latencyBuckets)].Load()
+	fmt.Fprintf(w, "dapptoon_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "dapptoon_request_duration_seconds_sum %g\n", time.Duration(latencySum.Load()).Seconds())
+	fmt.Fprintf(w, "dapptoon_request_duration_seconds_count %d\n", cumulative)
+	fmt.Fprintln(w, "# HELP dapptoon_request_duration_quantile_seconds Estimated response-time percentiles over the session.")
+	fmt.Fprintln(w, "# TYPE dapptoon_request_duration_quantile_seconds gauge")
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		fmt.Fprintf(w, "dapptoon_request_duration_quantile_seconds{quantile=\"%g\"} %g\n", q, latencyPercentile(q).Seconds())
+	}
+	fmt.Fprintln(w, "# HELP dapptoon_peak_active_requests Most requests in flight at once.")
+	fmt.Fprintln(w, "# TYPE dapptoon_peak_active_requests gauge")
+	fmt.Fprintf(w, "dapptoon_peak_active_requests %d\n", peakRequests.Load())
+	if cfg.MaxConns > 0 {
+		fmt.Fprintln(w, "# HELP dapptoon_active_requests Requests in flight now.")
+		fmt.Fprintln(w, "# TYPE dapptoon_active_requests gauge")
+		fmt.Fprintf(w, "dapptoon_active_requests %d\n", activeRequests.Load())
+		fmt.Fprintln(w, "# HELP dapptoon_max_active_requests The -max-conns limit on requests in flight.")
+		fmt.Fprintln(w, "# TYPE dapptoon_max_active_requests gauge")
+		fmt.Fprintf(w, "dapptoon_max_active_requests %d\n", cfg.MaxConns)
+		fmt.Fprintln(w, "# HELP dapptoon_busy_rejections_total Requests answered 503 for being over -max-conns.")
+		fmt.Fprintln(w, "# TYPE dapptoon_busy_rejections_total counter")
+		fmt.Fprintf(w, "dapptoon_busy_rejections_total %d\n", busyRequests.Load())
+	}
+	fmt.Fprintln(w, "# HELP dapptoon_uptime_seconds Time since the app started.")
+	fmt.Fprintln(w, "# TYPE dapptoon_uptime_seconds gauge")
+	fmt.Fprintf(w, "dapptoon_uptime_seconds %.0f\n", time.Since(startTime).Seconds())
+}
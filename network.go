@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lanCandidate is an address getLANIP considered, with a score where higher
+// means more likely to be reachable by other devices on the LAN.
+type lanCandidate struct {
+	iface string
+	ip    net.IP
+	score int
+}
+
+// virtualIfacePrefixes are interface names created by container and VM
+// software, whose addresses other devices usually can't reach.
+var virtualIfacePrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vmnet", "vboxnet", "vethernet", "zt", "tailscale",
+}
+
+// dockerBridge is Docker's default bridge network.
+var dockerBridge = &net.IPNet{IP: net.IPv4(172, 17, 0, 0), Mask: net.CIDRMask(16, 32)}
+
+func isVirtualIface(name string) bool {
+	name = strings.ToLower(name)
+	for _, prefix := range virtualIfacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(name, "vmware") || strings.Contains(name, "virtualbox")
+}
+
+// lanCandidates lists the IPv4 (or, with v6 set, global IPv6) addresses of
+// interfaces that are up and not loopback or point-to-point, best first.
+func lanCandidates(v6 bool) []lanCandidate {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var candidates []lanCandidate
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&(net.FlagLoopback|net.FlagPointToPoint) != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipnet.IP.To4()
+			if v6 {
+				// Link-local IPv6 needs a zone, which browsers won't accept in a URL
+				if ip != nil || !ipnet.IP.IsGlobalUnicast() {
+					continue
+				}
+				ip = ipnet.IP
+			} else if ip == nil || ip.IsLinkLocalUnicast() {
+				continue
+			}
+
+			score := 0
+			if ip.IsPrivate() {
+				score += 2
+			}
+			if isVirtualIface(iface.Name) || dockerBridge.Contains(ip) {
+				score -= 3
+			}
+			candidates = append(candidates, lanCandidate{iface: iface.Name, ip: ip, score: score})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	return candidates
+}
+
+// getLANIP picks the IPv4 address most likely to be reachable from other
+// devices, preferring private addresses on physical interfaces.
+func getLANIP() string {
+	candidates := lanCandidates(false)
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	// Log the options when the choice is a guess so it can be diagnosed,
+	// but only once per set of candidates since this is polled
+	if (len(candidates) > 1 && candidates[0].score == candidates[1].score) || candidates[0].score < 2 {
+		var desc []string
+		for _, c := range candidates {
+			desc = append(desc, fmt.Sprintf("%s (%s) score %d", c.ip, c.iface, c.score))
+		}
+		if joined := strings.Join(desc, ", "); joined != loggedCandidates {
+			loggedCandidates = joined
+			log.Println("LAN IP candidates:", joined)
+		}
+	}
+
+	return candidates[0].ip.String()
+}
+
+// loggedCandidates is the candidate list getLANIP last logged.
+var loggedCandidates string
+
+// getLANIPv6 returns the best global-unicast IPv6 address, or "" if the
+// machine has none.
+func getLANIPv6() string {
+	candidates := lanCandidates(true)
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0].ip.String()
+}
+
+// boundLANIP returns the -bind address when it's a single non-loopback
+// address, which is then the only one the server can be reached at.
+func boundLANIP() net.IP {
+	if ip := net.ParseIP(cfg.Bind); ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() {
+		return ip
+	}
+	return nil
+}
+
+// lanIPs returns the IPv4 and IPv6 addresses to give other devices.
+func lanIPs() (v4, v6 string) {
+	if ip := boundLANIP(); ip != nil {
+		if ip.To4() != nil {
+			return ip.String(), ""
+		}
+		return "", ip.String()
+	}
+	if ip := chosenLANIP(); ip != nil {
+		if ip.To4() != nil {
+			return ip.String(), getLANIPv6()
+		}
+		return "", ip.String()
+	}
+	return getLANIP(), getLANIPv6()
+}
+
+// lanChoice is the LAN IP to advertise instead of the automatic pick: the
+// -lan-ip address, or one picked in the tray. Empty means automatic.
+var lanChoice struct {
+	sync.Mutex
+	ip string
+}
+
+func chooseLANIP(ip string) {
+	lanChoice.Lock()
+	lanChoice.ip = ip
+	lanChoice.Unlock()
+}
+
+// chosenLANIP returns the address to advertise in place of the automatic
+// pick, or nil for none. -lan-ip is used as given, e.g. for a NAT address;
+// a tray pick only while the machine still has it, so a network switch
+// falls back to the automatic one.
+func chosenLANIP() net.IP {
+	lanChoice.Lock()
+	choice := lanChoice.ip
+	lanChoice.Unlock()
+	if choice == "" {
+		return nil
+	}
+	if choice == cfg.LANIP {
+		return net.ParseIP(choice)
+	}
+	for _, c := range lanCandidates(false) {
+		if c.ip.String() == choice {
+			return c.ip
+		}
+	}
+	return nil
+}
+
+// localHost is the host for this machine's own browser: localhost, unless
+// the server only listens on a LAN address.
+func localHost() string {
+	if ip := boundLANIP(); ip != nil {
+		return ip.String()
+	}
+	return "localhost"
+}
+
+// bindOverride replaces -bind once the tray's LAN Access switch is used,
+// flipping the server between loopback only and all interfaces.
+var bindOverride atomic.Pointer[string]
+
+// bindAddr returns the address the server listens on, "" for all interfaces.
+func bindAddr() string {
+	if bind := bindOverride.Load(); bind != nil {
+		return *bind
+	}
+	return cfg.Bind
+}
+
+// localOnly reports whether the server is restricted to loopback.
+func localOnly() bool {
+	bind := bindAddr()
+	ip := net.ParseIP(bind)
+	return bind == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// warnIfLocalOnly points out that the LAN URL and QR code can't work when
+// the server only listens on loopback, unless they lead to a tunnel.
+func warnIfLocalOnly() {
+	if localOnly() && cfg.PublicURL == "" {
+		msg := "Listening on " + bindAddr() + " only, so other devices can't connect to the LAN URL or QR code"
+		warn(msg)
+		notify(msg)
+	}
+}
+
+// hostURL builds a URL for host, bracketing IPv6 literals as URLs require.
+func hostURL(scheme, host string, port int) string {
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// parseCIDRs parses a list of networks like 192.168.1.0/24.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(c))
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// watchLANIP polls for a new LAN address, e.g. after switching from Wi-Fi
+// to Ethernet or a DHCP lease change, and updates the URLs to match.
+func watchLANIP(interval time.Duration) {
+	for range time.Tick(interval) {
+		if refreshLANIP() {
+			if url, _ := currentLANURLs(); url != "" && cfg.PublicURL == "" {
+				notify("LAN IP changed to " + url + " — QR updated")
+			}
+		}
+	}
+}
+
+// refreshLANIP detects the LAN addresses afresh and, if they changed,
+// updates the URLs, open QR pages, and mDNS to match.
+func refreshLANIP() (changed bool) {
+	v4, v6 := lanIPs()
+	old4, old6 := currentLANIPs()
+	if v4 == old4 && v6 == old6 {
+		return false
+	}
+
+	from, to := joinNonEmpty(old4, old6), joinNonEmpty(v4, v6)
+	logEvent("ip_changed", fmt.Sprintf("LAN IP changed from %q to %q", from, to), "from", from, "to", to)
+	setLANIPs(v4, v6)
+	ipChanges.broadcast()
+
+	// Re-announce so name.local resolves to the new address, unless LAN
+	// access is off and there's nothing to announce
+	if mdnsServer != nil && !localOnly() {
+		stopMDNS()
+		startMDNS(urlPort, v4, v6)
+	}
+	return true
+}
+
+// joinNonEmpty joins the non-empty strings with ", ".
+func joinNonEmpty(vals ...string) string {
+	var out []string
+	for _, v := range vals {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return strings.Join(out, ", ")
+}
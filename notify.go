@@ -0,0 +1,21 @@
+package main
+
+import "github.com/gen2brain/beeep"
+
+func init() {
+	beeep.AppName = "Dapptoon"
+}
+
+// notifyEnabled is false in headless mode, where there is no desktop to
+// notify.
+var notifyEnabled = true
+
+// notify shows a desktop notification, logging instead if that fails.
+func notify(message string) {
+	if !notifyEnabled {
+		return
+	}
+	if err := beeep.Notify("Dapptoon", message, iconData); err != nil {
+		warn("Failed to show notification: " + err.Error())
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// proxyRoute forwards requests under Prefix to a backend given with -proxy.
+type proxyRoute struct {
+	Prefix string
+	Target *url.URL
+}
+
+// reservedPaths are the app's own endpoints, which a -proxy prefix can't
+// take over.
+var reservedPaths = []string{
+	"/qr", "/qr/events", "/qr/wifi", "/metrics", "/healthz", "/livereload",
+	"/asset-hashes.json", "/events", "/broadcast", "/upload", "/shutdown",
+	"/__info", "/ws-echo", "/favicon.ico",
+}
+
+// parseProxy parses "/prefix=http://host:port".
+func parseProxy(v string) (proxyRoute, error) {
+	prefix, target, ok := strings.Cut(v, "=")
+	if !ok || !strings.HasPrefix(prefix, "/") || prefix == "/" {
+		return proxyRoute{}, errors.New(`expected "/prefix=http://host:port"`)
+	}
+	if trimmed := strings.TrimSuffix(prefix, "/"); slices.Contains(reservedPaths, trimmed) {
+		return proxyRoute{}, fmt.Errorf("%s is one of the app's own paths", trimmed)
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return proxyRoute{}, fmt.Errorf("invalid backend URL %q", target)
+	}
+	return proxyRoute{Prefix: strings.TrimSuffix(prefix, "/"), Target: u}, nil
+}
+
+// newProxy forwards requests to p's backend with their path intact, so
+// /api/users goes to http://localhost:3000/api/users, and marks them with
+// X-Forwarded-For, -Host, and -Proto so the backend sees the real client.
+func newProxy(p proxyRoute) http.Handler {
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(p.Target)
+			r.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Proxy %s %s to %s failed: %v", r.Method, r.URL.Path, p.Target, err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if verboseLogs.Load() {
+			log.Printf("Proxy %s %s -> %s", r.Method, r.URL.RequestURI(), p.Target)
+		}
+		rp.ServeHTTP(w, r)
+	})
+}
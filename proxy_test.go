@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/mstenq/dapptoon/server"
+)
+
+func TestProxyHead(t *testing.T) {
+	body := "backend says hello"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write([]byte(body))
+	}))
+	defer backend.Close()
+
+	route, err := parseProxy("/api=" + backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := server.HeadAsGet(newProxy(route))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Head(srv.URL + "/api/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("Content-Length = %d, want the backend's %d", resp.ContentLength, len(body))
+	}
+}
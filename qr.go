@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// QR codes use these unless configured with -qr-level, -qr-size, and
+// -qr-format.
+var (
+	qrLevel  = qrcode.Medium
+	qrSize   = 256
+	qrFormat = "png"
+)
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"low":     qrcode.Low,
+	"medium":  qrcode.Medium,
+	"high":    qrcode.High,
+	"highest": qrcode.Highest,
+}
+
+// setQROptions applies the configured error-correction level, image size,
+// and format, keeping the defaults for values that don't make sense.
+func setQROptions(level string, size int, format string) {
+	if l, ok := qrLevels[strings.ToLower(level)]; ok {
+		qrLevel = l
+	} else {
+		log.Printf("Unknown -qr-level %q, using medium (choose low, medium, high, or highest)", level)
+	}
+	if size >= 64 && size <= 4096 {
+		qrSize = size
+	} else {
+		log.Printf("Invalid -qr-size %d, using %d (must be between 64 and 4096)", size, qrSize)
+	}
+	switch format = strings.ToLower(format); format {
+	case "png", "svg":
+		qrFormat = format
+	default:
+		log.Printf("Unknown -qr-format %q, using png (choose png or svg)", format)
+	}
+}
+
+// errNoLANIP means there's no LAN URL to put in a QR code.
+var errNoLANIP = errors.New("no LAN IP available")
+
+// qrPNG encodes the URL to share as a QR code image, for the clipboard,
+// which only takes PNGs.
+func qrPNG() ([]byte, error) {
+	url := shareURL()
+	if url == "" {
+		return nil, errNoLANIP
+	}
+	return qrcode.Encode(url, qrLevel, qrSize)
+}
+
+// encodeQR renders content as a QR code in -qr-format, returning the image
+// and its Content-Type.
+func encodeQR(content string) ([]byte, string, error) {
+	if qrFormat != "svg" {
+		png, err := qrcode.Encode(content, qrLevel, qrSize)
+		return png, "image/png", err
+	}
+	qr, err := qrcode.New(content, qrLevel)
+	if err != nil {
+		return nil, "", err
+	}
+	return qrSVG(qr), "image/svg+xml", nil
+}
+
+// qrSVG draws the QR bitmap as one path of unit squares, scaled to qrSize,
+// so it stays sharp at any size when printed or embedded.
+func qrSVG(qr *qrcode.QRCode) []byte {
+	bitmap := qr.Bitmap()
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, qrSize, qrSize, len(bitmap), len(bitmap))
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
+
+// wifiSecurities are the -wifi-security values phones understand.
+var wifiSecurities = map[string]string{"wpa": "WPA", "wep": "WEP", "nopass": "nopass"}
+
+// wifiJoin is the network string for a QR code that offers to join the
+// -wifi-ssid network, or "" without one.
+func wifiJoin() string {
+	if cfg.WiFiSSID == "" {
+		return ""
+	}
+	esc := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`)
+	security := wifiSecurities[strings.ToLower(cfg.WiFiSecurity)]
+	s := "WIFI:T:" + security + ";S:" + esc.Replace(cfg.WiFiSSID) + ";"
+	if security != "nopass" {
+		s += "P:" + esc.Replace(cfg.WiFiPassword) + ";"
+	}
+	return s + ";"
+}
+
+// printWiFiQR prints the Wi-Fi join code ahead of the URL's, for events
+// where people aren't on the network yet.
+func printWiFiQR() {
+	if wifi := wifiJoin(); wifi != "" {
+		sayf("Scan to join Wi-Fi %q, then scan the code below:\n", cfg.WiFiSSID)
+		printQR(wifi)
+	}
+}
+
+// serveWiFiQR renders the Wi-Fi join code as a PNG. It carries the
+// password, so only the local machine gets it; the page on screen there is
+// what attendees scan.
+func serveWiFiQR(w http.ResponseWriter, r *http.Request) {
+	wifi := wifiJoin()
+	if wifi == "" || !clientIP(r).IsLoopback() {
+		http.NotFound(w, r)
+		return
+	}
+	img, contentType, err := encodeQR(wifi)
+	if err != nil {
+		http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(img)
+}
+
+// qrPage shows the QR code with its URL, and reloads when the LAN IP
+// changes so a code left open on screen never goes stale. With -wifi-ssid,
+// the local machine's copy puts the Wi-Fi join code first; with -dual-stack,
+// the IPv6 URL gets a code of its own after the main one.
+var qrPage = template.Must(template.New("qr").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Dapptoon QR code</title>
+<style>
+body { font-family: system-ui, sans-serif; text-align: center; margin: 3em 1em; }
+img { image-rendering: pixelated; max-width: 90vmin; }
+p { font-size: 1.25em; word-break: break-all; }
+</style>
+</head>
+<body>
+{{if .SSID}}<img src="qr/wifi" alt="QR code to join {{.SSID}}">
+<p>1. Join the Wi-Fi network {{.SSID}}</p>
+{{end}}{{with .URL}}<img src="qr" alt="QR code for {{.}}">
+<p>{{if $.SSID}}2. Open {{end}}<a href="{{.}}">{{.}}</a></p>
+{{else}}<p>No LAN IP available yet. This page will update when one appears.</p>
+{{end}}{{with .URL6}}<img src="qr?ipv6" alt="QR code for {{.}}">
+<p>Over IPv6: <a href="{{.}}">{{.}}</a></p>
+{{end}}
+<script>new EventSource("qr/events").onmessage = () => location.reload();</script>
+</body>
+</html>
+`))
+
+// serveQR renders the current LAN URL as a PNG, or the IPv6 one with
+// ?ipv6, so the QR can be shown in the browser without writing anything to
+// disk. Browsers navigating to it
+// get a page around the image instead, which follows IP changes.
+func serveQR(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		var ssid string
+		if clientIP(r).IsLoopback() {
+			ssid = cfg.WiFiSSID
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		qrPage.Execute(w, struct{ URL, URL6, SSID string }{shareURL(), dualStackURL6(), ssid})
+		return
+	}
+
+	url := shareURL()
+	if r.URL.Query().Has("ipv6") {
+		url = dualStackURL6()
+	}
+	if url == "" {
+		http.Error(w, "No LAN IP available", http.StatusServiceUnavailable)
+		return
+	}
+	img, contentType, err := encodeQR(url)
+	if err != nil {
+		http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(img)
+}
+
+// dualStackURL6 is the IPv6 LAN URL advertised on its own with -dual-stack,
+// or "" when there's none besides the main URL.
+func dualStackURL6() string {
+	url, url6 := currentLANURLs()
+	if !cfg.DualStack || cfg.PublicURL != "" || url6 == url {
+		return ""
+	}
+	return url6
+}
+
+// sessionQR is the temp file saveQR writes to without -qr-out. It's reused
+// so saving again after an IP change replaces the old code rather than
+// leaving stale files behind.
+var sessionQR string
+
+// saveQR writes the QR code for the URL to share to out, or to this
+// session's temp file if out is empty, and returns the path written.
+func saveQR(out string) (string, error) {
+	if out == "" {
+		if sessionQR == "" {
+			f, err := os.CreateTemp("", "dapptoon-qr-*."+qrFormat)
+			if err != nil {
+				return "", err
+			}
+			f.Close()
+			sessionQR = f.Name()
+		}
+		out = sessionQR
+	}
+
+	url := shareURL()
+	if url == "" {
+		return "", errNoLANIP
+	}
+	img, _, err := encodeQR(url)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(out, img, 0644); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// removeSessionQR deletes this session's temp QR file on quit, unless
+// -qr-keep asks for it to stay. Files saved to -qr-out are always kept.
+func removeSessionQR() {
+	if sessionQR != "" && !cfg.QRKeep {
+		os.Remove(sessionQR)
+		sessionQR = ""
+	}
+}
+
+// printQR writes url as a QR code to the terminal for scanning in
+// environments without a tray or browser.
+func printQR(url string) {
+	qr, err := qrcode.New(url, qrLevel)
+	if err != nil {
+		log.Println("Failed to generate QR code:", err)
+		return
+	}
+	fmt.Print(terminalQR(qr))
+}
+
+// terminalQR renders the QR bitmap with half-block characters, packing two
+// module rows into each line of text. Light modules are drawn as blocks so
+// the code reads correctly on the usual dark terminal background, and the
+// quiet-zone border is kept so scanners can lock on. With plainOutput it
+// uses "##" instead, a line per row.
+func terminalQR(qr *qrcode.QRCode) string {
+	qr.DisableBorder = false
+	bitmap := qr.Bitmap()
+
+	var b strings.Builder
+	if plainOutput {
+		// Two characters a module, one line a row, since half blocks aren't ASCII
+		for _, row := range bitmap {
+			for _, dark := range row {
+				if dark {
+					b.WriteString("  ")
+				} else {
+					b.WriteString("##")
+				}
+			}
+			b.WriteByte('\n')
+		}
+		return b.String()
+	}
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			// bitmap is true for dark modules; past the last row counts as border
+			top := !bitmap[y][x]
+			bottom := y+1 >= len(bitmap) || !bitmap[y+1][x]
+			switch {
+			case top && bottom:
+				b.WriteRune('█')
+			case top:
+				b.WriteRune('▀')
+			case bottom:
+				b.WriteRune('▄')
+			default:
+				b.WriteRune(' ')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
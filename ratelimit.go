@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter keeps a token bucket per client IP, so one runaway client
+// can't starve the machine or other devices.
+type rateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*rateClient
+	limit   rate.Limit
+	burst   int
+}
+
+type rateClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// idleClientTTL is how long an IP's bucket is kept after its last request;
+// a bucket idle that long has refilled anyway.
+const idleClientTTL = 3 * time.Minute
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Ceil(perSecond))
+	}
+	return &rateLimiter{clients: make(map[string]*rateClient), limit: rate.Limit(perSecond), burst: burst}
+}
+
+// reserve takes a token from ip's bucket, if one is available, and
+// otherwise reports how long until one will be.
+func (l *rateLimiter) reserve(ip string) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	c, found := l.clients[ip]
+	if !found {
+		// Prune idle clients only when adding one, to keep the map small
+		for k, v := range l.clients {
+			if now.Sub(v.lastSeen) >= idleClientTTL {
+				delete(l.clients, k)
+			}
+		}
+		c = &rateClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = c
+	}
+	c.lastSeen = now
+
+	res := c.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// middleware answers over-limit requests with 429 and a Retry-After in
+// whole seconds. Loopback is exempt so local dev tools aren't throttled.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if ip == nil || ip.IsLoopback() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, retryAfter := l.reserve(ip.String()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// redirectRule sends requests for From to To, given with -redirect. A From
+// ending in /* matches everything under it, and a To ending in /* gets the
+// rest of the path, so /docs/*=/guide/* moves a whole section.
+type redirectRule struct {
+	From   string
+	To     string
+	Prefix bool
+}
+
+// parseRedirect parses "/from=/to", where /to may also be a full URL.
+func parseRedirect(v string) (redirectRule, error) {
+	from, to, ok := strings.Cut(v, "=")
+	if !ok || !strings.HasPrefix(from, "/") || to == "" {
+		return redirectRule{}, errors.New(`expected "/from=/to"`)
+	}
+	if !strings.HasPrefix(to, "/") {
+		if u, err := url.Parse(to); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return redirectRule{}, fmt.Errorf("invalid target %q: expected a path or an http URL", to)
+		}
+	}
+	rule := redirectRule{From: from, To: to}
+	if prefix, ok := strings.CutSuffix(from, "/*"); ok {
+		rule.From, rule.Prefix = prefix, true
+	}
+	// A target the rule matches itself would redirect forever
+	if dest, _, _ := strings.Cut(strings.TrimSuffix(to, "/*"), "?"); strings.HasPrefix(dest, "/") && rule.target(dest) != "" {
+		return redirectRule{}, fmt.Errorf("%s redirects to itself", v)
+	}
+	return rule, nil
+}
+
+// target returns where rule sends a request for p, or "" if it doesn't
+// match.
+func (rule redirectRule) target(p string) string {
+	if !rule.Prefix {
+		if p != rule.From {
+			return ""
+		}
+		return strings.TrimSuffix(rule.To, "/*")
+	}
+	rest, ok := strings.CutPrefix(p, rule.From)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return ""
+	}
+	if base, ok := strings.CutSuffix(rule.To, "/*"); ok {
+		return base + rest
+	}
+	return rule.To
+}
+
+// redirects answers requests matching a rule with status and the rule's
+// target, checking rules in the order given, and passes the rest to next.
+// Paths are within the base path, which a path target is kept under.
+func redirects(rules []redirectRule, status int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rule := range rules {
+			to := rule.target(r.URL.Path)
+			if to == "" {
+				continue
+			}
+			if strings.HasPrefix(to, "/") {
+				to = cfg.BasePath + to
+			}
+			// Keep the query, unless the target has its own
+			if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+				to += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, to, status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
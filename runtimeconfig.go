@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// runtimeSetting is a -runtime-config entry, exposed to the app as
+// window.__CONFIG__[Key].
+type runtimeSetting struct {
+	Key, Value string
+}
+
+// parseRuntimeSetting parses "key=value".
+func parseRuntimeSetting(v string) (runtimeSetting, error) {
+	key, value, ok := strings.Cut(v, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return runtimeSetting{}, errors.New(`expected "key=value"`)
+	}
+	return runtimeSetting{Key: key, Value: value}, nil
+}
+
+// configMarker is where the config script goes in a page that has one;
+// otherwise it's added before </head>, ahead of the app's scripts.
+const configMarker = "<!--CONFIG-->"
+
+// injectConfig adds a script defining window.__CONFIG__ from settings to
+// HTML pages, so the app learns addresses that depend on the network at
+// run time. Values can use {host}, {origin}, {lanIP}, and {lanURL}, where
+// host and origin are the ones the browser used: a phone that reached the
+// LAN IP gets the LAN IP, the local browser gets localhost.
+func injectConfig(settings []runtimeSetting, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The page differs with the host and LAN IP, so a browser's cached
+		// copy can't be revalidated by ETag; navigations always get it fresh
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			r = r.Clone(r.Context())
+			r.Header.Del("If-None-Match")
+			r.Header.Del("If-Modified-Since")
+		}
+
+		iw := &injectWriter{ResponseWriter: w}
+		next.ServeHTTP(iw, identityPage(r))
+		if iw.inject {
+			w.Header().Del("ETag")
+			w.Header().Del("Last-Modified")
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		script := configScript(settings, r)
+		iw.finish(func(page []byte) []byte {
+			if i := bytes.Index(page, []byte(configMarker)); i >= 0 {
+				return bytes.Replace(page, []byte(configMarker), []byte(script), 1)
+			}
+			return insertBefore(page, "</head>", script)
+		})
+	})
+}
+
+// configScript renders settings for r, with placeholders filled in.
+func configScript(settings []runtimeSetting, r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	ip, _ := currentLANIPs()
+	url, _ := currentLANURLs()
+	expand := strings.NewReplacer("{host}", host, "{origin}", scheme+"://"+r.Host, "{lanIP}", ip, "{lanURL}", url)
+
+	values := make(map[string]string, len(settings))
+	for _, s := range settings {
+		values[s.Key] = expand.Replace(s.Value)
+	}
+	// Marshal escapes <, >, and &, so values can't close the script
+	data, _ := json.Marshal(values)
+	return fmt.Sprintf("<script>window.__CONFIG__=%s</script>", data)
+}
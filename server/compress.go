@@ -0,0 +1,239 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressibleTypes are the Content-Type prefixes worth compressing; images
+// other than SVG, fonts, and media are already compressed.
+var compressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"image/svg+xml",
+}
+
+func isCompressible(contentType string) bool {
+	// Event streams must reach the client as they're written
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return false
+	}
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsEncoding reports whether the client listed enc in Accept-Encoding
+// without disabling it via q=0.
+func acceptsEncoding(r *http.Request, enc string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), enc) {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// encoders create a compressing writer for each supported Content-Encoding.
+var encoders = map[string]func(io.Writer) io.WriteCloser{
+	"br":   func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+	"gzip": func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+}
+
+// encodedETag returns the ETag of the enc-compressed representation of a
+// response tagged tag, so caches never take one encoding for the other.
+func encodedETag(tag, enc string) string {
+	return strings.TrimSuffix(tag, `"`) + "-" + enc + `"`
+}
+
+// identityIfNoneMatch adds to an If-None-Match header the identity tag of
+// each tag encodedETag made for enc, so the handler, which only knows the
+// uncompressed tag, can still answer 304. The encoded tags are kept for
+// Precompressed, which tags its files the same way.
+func identityIfNoneMatch(header, enc string) string {
+	suffix := "-" + enc + `"`
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); strings.HasSuffix(tag, suffix) {
+			tags = append(tags, strings.TrimSuffix(tag, suffix)+`"`)
+		}
+	}
+	if len(tags) == 0 {
+		return header
+	}
+	return header + ", " + strings.Join(tags, ", ")
+}
+
+// compressResponseWriter decides whether to compress once the status and
+// Content-Type are known, which is when the header is written.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	method      string
+	encoding    string
+	ifNoneMatch string
+	enc         io.WriteCloser
+	wroteHeader bool
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	switch {
+	case code == http.StatusOK && h.Get("Content-Encoding") == "" && isCompressible(h.Get("Content-Type")):
+		h.Add("Vary", "Accept-Encoding")
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		// The compressed body is a different representation
+		if tag := h.Get("ETag"); tag != "" {
+			h.Set("ETag", encodedETag(tag, w.encoding))
+		}
+		if w.method != http.MethodHead {
+			w.enc = encoders[w.encoding](w.ResponseWriter)
+		}
+	case code == http.StatusNotModified:
+		// Confirm the copy the client has: the compressed one if that's the
+		// tag it sent, else the uncompressed one it got before
+		h.Add("Vary", "Accept-Encoding")
+		if tag := h.Get("ETag"); tag != "" && strings.Contains(w.ifNoneMatch, encodedETag(tag, w.encoding)) {
+			h.Set("ETag", encodedETag(tag, w.encoding))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressResponseWriter) close() {
+	if w.enc != nil {
+		w.enc.Close()
+	}
+}
+
+// Compress compresses compressible responses with the first of
+// encodings, in order of preference, that the client accepts; clients that
+// accept none get the identity encoding. Range requests are passed through
+// untouched, since byte ranges refer to the uncompressed file. A compressed
+// response gets its own ETag, which still revalidates with 304.
+func Compress(encodings []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, enc := range encodings {
+			if acceptsEncoding(r, enc) {
+				inm := r.Header.Get("If-None-Match")
+				if inm != "" {
+					r = r.Clone(r.Context())
+					r.Header.Set("If-None-Match", identityIfNoneMatch(inm, enc))
+				}
+				cw := &compressResponseWriter{ResponseWriter: w, method: r.Method, encoding: enc, ifNoneMatch: inm}
+				defer cw.close()
+				next.ServeHTTP(cw, r)
+				return
+			}
+		}
+		// A shared cache must still keep this apart from the compressed one
+		next.ServeHTTP(&hookWriter{ResponseWriter: w, hook: func(int) {
+			if isCompressible(w.Header().Get("Content-Type")) {
+				w.Header().Add("Vary", "Accept-Encoding")
+			}
+		}}, r)
+	})
+}
+
+// precompressedExts are the sibling files build tools write for each
+// encoding, in order of preference.
+var precompressedExts = []struct{ encoding, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Precompressed serves a file's .br or .gz sibling, when the build emitted
+// one and the client accepts it, instead of compressing on the fly. A
+// folder is its index.html, as FileServer serves it, so / and the SPA
+// fallback get index.html.br too. Range requests and files without a
+// sibling go to next.
+func Precompressed(root http.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name := path.Clean(r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") {
+			name = path.Join(name, "index.html")
+			f, err := root.Open(name)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			f.Close()
+		}
+		for _, pc := range precompressedExts {
+			if !acceptsEncoding(r, pc.encoding) {
+				continue
+			}
+			f, err := root.Open(name + pc.ext)
+			if err != nil {
+				continue
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil || info.IsDir() {
+				continue
+			}
+
+			h := w.Header()
+			contentType := mime.TypeByExtension(path.Ext(name))
+			if contentType == "" {
+				// Sniffing would see the compressed bytes
+				contentType = "application/octet-stream"
+			}
+			h.Set("Content-Type", contentType)
+			h.Set("Content-Encoding", pc.encoding)
+			h.Add("Vary", "Accept-Encoding")
+			// The compressed file is a different representation
+			if tag := h.Get("ETag"); tag != "" {
+				h.Set("ETag", encodedETag(tag, pc.encoding))
+			}
+			http.ServeContent(w, r, name, info.ModTime(), f)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
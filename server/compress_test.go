@@ -0,0 +1,91 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPage = "<html><body>hello, compressed world</body></html>"
+
+// taggedPage serves testPage with the ETag "v1", answering If-None-Match
+// the way FileServer does.
+var taggedPage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("ETag", `"v1"`)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "index.html", time.Time{}, strings.NewReader(testPage))
+})
+
+// compressed serves a request for / with the given request headers through
+// Compress.
+func compressed(encodings []string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	Compress(encodings, taggedPage).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCompressStaleETag(t *testing.T) {
+	rec := compressed([]string{"gzip"}, map[string]string{"Accept-Encoding": "gzip", "If-None-Match": `"v0-gzip"`})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 for a stale tag", rec.Code)
+	}
+	if got := rec.Header().Get("ETag"); got != `"v1-gzip"` {
+		t.Errorf("ETag = %q, want the gzip tag", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil || string(body) != testPage {
+		t.Errorf("body = %q, %v, want the page", body, err)
+	}
+}
+
+func TestCompressMatchingETag(t *testing.T) {
+	rec := compressed([]string{"gzip"}, map[string]string{"Accept-Encoding": "gzip", "If-None-Match": `"v1-gzip"`})
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want 304 for the current gzip tag", rec.Code)
+	}
+	if got := rec.Header().Get("ETag"); got != `"v1-gzip"` {
+		t.Errorf("ETag = %q, want the gzip tag the client sent", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", got)
+	}
+}
+
+func TestCompressIdentityETag(t *testing.T) {
+	// A copy cached before compression was turned on is still current
+	rec := compressed([]string{"gzip"}, map[string]string{"Accept-Encoding": "gzip", "If-None-Match": `"v1"`})
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want 304 for the current identity tag", rec.Code)
+	}
+	if got := rec.Header().Get("ETag"); got != `"v1"` {
+		t.Errorf("ETag = %q, want the identity tag the client sent", got)
+	}
+}
+
+func TestCompressPrefersBrotli(t *testing.T) {
+	for _, accept := range []string{"br, gzip", "gzip, br", "gzip, deflate, br, zstd"} {
+		rec := compressed([]string{"br", "gzip"}, map[string]string{"Accept-Encoding": accept})
+		if got := rec.Header().Get("Content-Encoding"); got != "br" {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want br", accept, got)
+		}
+	}
+	rec := compressed([]string{"br", "gzip"}, map[string]string{"Accept-Encoding": "br;q=0, gzip"})
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("br refused: Content-Encoding = %q, want gzip", got)
+	}
+}
@@ -0,0 +1,487 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// SPAFallback serves index.html for client-side routes that have no matching
+// file, so deep links and refreshes work. Missing assets (paths with an
+// extension) get the 404 page instead.
+func SPAFallback(root http.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			f, err := root.Open(path.Clean(r.URL.Path))
+			if errors.Is(err, fs.ErrNotExist) {
+				if path.Ext(r.URL.Path) != "" {
+					ServeNotFound(root, w, r)
+					return
+				}
+				// FileServer serves index.html for the root directory
+				r2 := r.Clone(r.Context())
+				r2.URL.Path = "/"
+				next.ServeHTTP(w, r2)
+				return
+			}
+			if err == nil {
+				f.Close()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ExactPaths serves the file at exactly the requested path and nothing
+// else: no folder index page, no trailing-slash redirect, and no fallback.
+// Folders and missing files get the 404 page, so URLs map 1:1 to files, as
+// a documentation site expects.
+func ExactPaths(root http.FileSystem) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := root.Open(path.Clean(r.URL.Path))
+		if errors.Is(err, fs.ErrPermission) {
+			http.Error(w, "403 Forbidden", http.StatusForbidden)
+			return
+		}
+		if err == nil {
+			defer f.Close()
+		}
+		var info fs.FileInfo
+		if err == nil {
+			info, err = f.Stat()
+		}
+		if err != nil || info.IsDir() || strings.HasSuffix(r.URL.Path, "/") {
+			// Any ETag set for the path belongs to a file that isn't there
+			w.Header().Del("ETag")
+			ServeNotFound(root, w, r)
+			return
+		}
+		// Unlike FileServer, which redirects /index.html to the folder
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	})
+}
+
+// HideDirs makes root's folders, other than the root itself, look missing,
+// so requests for them reach SPAFallback or a 404 instead of FileServer's
+// trailing-slash redirect and folder index page. FileServer still
+// redirects /folder/index.html to /folder/, which then goes the same way.
+func HideDirs(root http.FileSystem) http.FileSystem {
+	return hiddenDirs{root}
+}
+
+type hiddenDirs struct {
+	http.FileSystem
+}
+
+func (h hiddenDirs) Open(name string) (http.File, error) {
+	f, err := h.FileSystem.Open(name)
+	if err != nil || path.Clean("/"+name) == "/" {
+		return f, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	return f, nil
+}
+
+// IndexFile serves name for folder requests, for builds whose entry page
+// isn't index.html. Folders without it are left to next.
+func IndexFile(root http.FileSystem, name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			index := path.Join(r.URL.Path, name)
+			if f, err := root.Open(index); err == nil {
+				f.Close()
+				r2 := r.Clone(r.Context())
+				r2.URL.Path = index
+				next.ServeHTTP(w, r2)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SniffExtensionless sets the Content-Type of files without an extension
+// from their content, where FileServer's own sniffing falls short: it
+// can't recognize JSON, and anything it can't place becomes
+// application/octet-stream, which browsers download. Only HTML, JSON, and
+// images are set; other files get FileServer's usual answer, as do Range
+// requests, which shouldn't cost a read of the file's start.
+func SniffExtensionless(root http.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean(r.URL.Path)
+		if path.Ext(name) != "" || strings.HasSuffix(r.URL.Path, "/") || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if contentType := sniff(root, name); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxSniffJSON is the largest file checked for being JSON, since that means
+// parsing it whole.
+const maxSniffJSON = 1 << 20
+
+// sniffLen is how much of a file http.DetectContentType looks at.
+const sniffLen = 512
+
+// sniff returns the type of the file at name when it's confidently HTML,
+// JSON, or an image, and "" otherwise. Only a file whose start looks like
+// JSON is read further.
+func sniff(root http.FileSystem, name string) string {
+	f, err := root.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		return ""
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return ""
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	switch {
+	case strings.HasPrefix(contentType, "text/html"), strings.HasPrefix(contentType, "image/"):
+		return contentType
+	case !strings.HasPrefix(contentType, "text/plain"):
+		return ""
+	}
+	if trimmed := bytes.TrimLeft(head, " \t\r\n"); len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return ""
+	}
+	rest, err := io.ReadAll(io.LimitReader(f, maxSniffJSON+1-int64(n)))
+	if err != nil {
+		return ""
+	}
+	if data := append(head, rest...); len(data) <= maxSniffJSON && json.Valid(data) {
+		return "application/json"
+	}
+	return ""
+}
+
+// ServeNotFound responds 404 with the app's own 404.html when it has one,
+// so error states match the rest of the site.
+func ServeNotFound(root http.FileSystem, w http.ResponseWriter, r *http.Request) {
+	if !serveErrorPage(root, "/404.html", http.StatusNotFound, w, r) {
+		http.NotFound(w, r)
+	}
+}
+
+// serveErrorPage responds with status and the page at name in root,
+// reporting false without responding when root has no such page.
+func serveErrorPage(root http.FileSystem, name string, status int, w http.ResponseWriter, r *http.Request) bool {
+	f, err := root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	page, err := io.ReadAll(f)
+	if err != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(page)
+	}
+	return true
+}
+
+// internalErrorPage is what Recover sends when the app has no 500.html.
+const internalErrorPage = `<!doctype html>
+<html>
+<head><meta charset="utf-8"><title>500 Internal Server Error</title></head>
+<body style="font-family: system-ui, sans-serif; max-width: 32em; margin: 3em auto; padding: 0 1em">
+<h1>Something went wrong</h1>
+<p>The server hit an error handling this request. The details are in its log.</p>
+</body>
+</html>
+`
+
+// Recover answers a panic in next with a 500, logging the stack, instead
+// of dropping the connection. The page is the app's own 500.html from
+// root() when it has one. A response that had already started can't be
+// replaced, so that connection is still cut short, as is one a WebSocket
+// or other upgrade took over.
+func Recover(root func() http.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &startedWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL.RequestURI(), err, debug.Stack())
+			// The server no longer owns a hijacked connection, so it's
+			// ours to close
+			if rw.hijacked != nil {
+				rw.hijacked.Close()
+				return
+			}
+			if rw.started {
+				panic(http.ErrAbortHandler)
+			}
+			// Headers set along the way, like caching or a content length,
+			// don't describe the error page
+			for name := range w.Header() {
+				w.Header().Del(name)
+			}
+			if !serveErrorPage(root(), "/500.html", http.StatusInternalServerError, w, r) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusInternalServerError)
+				io.WriteString(w, internalErrorPage)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// startedWriter records whether the response has begun, and the
+// connection if it was hijacked.
+type startedWriter struct {
+	http.ResponseWriter
+	started  bool
+	hijacked net.Conn
+}
+
+func (w *startedWriter) WriteHeader(code int) {
+	// 1xx responses like 103 Early Hints don't start the final response
+	if code >= 200 {
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *startedWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *startedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.started, w.hijacked = true, conn
+	}
+	return conn, buf, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *startedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// BasicAuth rejects requests that don't carry the expected credentials.
+// Both fields are compared in constant time so timing doesn't leak them.
+func BasicAuth(user, pass string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(p), []byte(pass)) == 1
+		if !ok || !userOK || !passOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dapptoon", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CORS lets pages on the given origins, or any origin if one is "*", fetch
+// from the server. Preflights are answered directly with 204, since the
+// files are all the server has and they only support reads.
+func CORS(origins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, o := range origins {
+		allowed[strings.TrimSuffix(strings.TrimSpace(o), "/")] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		switch {
+		case allowed["*"]:
+			h.Set("Access-Control-Allow-Origin", "*")
+		case allowed[origin]:
+			h.Set("Access-Control-Allow-Origin", origin)
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+				h.Set("Access-Control-Allow-Headers", reqHeaders)
+			}
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hookWriter calls hook with the status code just before the header is
+// written, so middleware can set headers that depend on the outcome.
+type hookWriter struct {
+	http.ResponseWriter
+	hook        func(status int)
+	wroteHeader bool
+}
+
+func (w *hookWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.hook(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *hookWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *hookWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// DefaultHashPattern matches build output with a content hash in the name,
+// like main.abc123ef.js or index-BXk3a9Zq.css.
+const DefaultHashPattern = `[.-][A-Za-z0-9_-]{8,}\.[A-Za-z0-9]+$`
+
+// CacheHeaders lets browsers keep fingerprinted assets forever, since a new
+// build changes their names, while making them revalidate index.html.
+func CacheHeaders(hashed *regexp.Regexp, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cacheControl string
+		p := r.URL.Path
+		switch {
+		case strings.HasSuffix(p, "/") || path.Base(p) == "index.html" || path.Ext(p) == "":
+			// Directories and SPA routes are answered with index.html
+			cacheControl = "no-cache"
+		case hashed.MatchString(p):
+			cacheControl = "public, max-age=31536000, immutable"
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Only cache successful responses, never a 404 for a missing asset
+		next.ServeHTTP(&hookWriter{ResponseWriter: w, hook: func(status int) {
+			if status < 400 {
+				w.Header().Set("Cache-Control", cacheControl)
+			}
+		}}, r)
+	})
+}
+
+// HeadAsGet answers HEAD requests by running them as GET and discarding the
+// body, so they get exactly the headers a GET would, Content-Length
+// included. Middleware that transforms the body, like Compress, would
+// otherwise have to guess at headers it can only know from the body. When
+// the header already has the length, as FileServer's does, it goes out
+// right away and the body is discarded without being counted.
+func HeadAsGet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.Method = http.MethodGet
+		hw := &headWriter{ResponseWriter: w}
+		next.ServeHTTP(hw, r2)
+		hw.finish(true)
+	})
+}
+
+// headWriter holds back the header until the body has been counted, then
+// writes it with the Content-Length the body would have had. Once the
+// header is out, writes are dropped but still succeed, as net/http's own
+// HEAD responses do, since handlers like ReverseProxy give up on an error.
+type headWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+	done   bool
+}
+
+func (w *headWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if w.Header().Get("Content-Length") != "" {
+		w.finish(false)
+	}
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.done {
+		w.n += int64(len(b))
+	}
+	return len(b), nil
+}
+
+// Flush means the response is a stream, whose length is never known, so
+// the header goes out as it is.
+func (w *headWriter) Flush() {
+	w.finish(false)
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *headWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *headWriter) finish(complete bool) {
+	if w.done {
+		return
+	}
+	w.done = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	bodyAllowed := w.status >= 200 && w.status != http.StatusNoContent && w.status != http.StatusNotModified
+	if complete && bodyAllowed && h.Get("Content-Length") == "" {
+		h.Set("Content-Length", strconv.FormatInt(w.n, 10))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func panics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=3600")
+	panic("boom")
+}
+
+func TestRecoverBuiltInPage(t *testing.T) {
+	h := Recover(func() http.FileSystem { return http.FS(testBuild) }, http.HandlerFunc(panics))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Something went wrong") {
+		t.Errorf("body = %q, want the built-in error page", rec.Body.String())
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "" {
+		t.Errorf("Cache-Control = %q, want the handler's headers dropped", got)
+	}
+}
+
+func TestRecoverAppPage(t *testing.T) {
+	root := fstest.MapFS{"500.html": {Data: []byte("<h1>Our fault</h1>")}}
+	h := Recover(func() http.FileSystem { return http.FS(root) }, http.HandlerFunc(panics))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusInternalServerError || rec.Body.String() != "<h1>Our fault</h1>" {
+		t.Errorf("got %d %q, want 500 with the app's 500.html", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRecoverAfterHijack(t *testing.T) {
+	h := Recover(func() http.FileSystem { return http.FS(testBuild) }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+		panic("boom")
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	// The connection is closed rather than sent an error page
+	rest, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatalf("reading after the panic: %v, want the connection closed", err)
+	}
+	if len(rest) > 0 {
+		t.Errorf("got %q after the upgrade, want nothing", rest)
+	}
+}
+
+func TestHeadAsGetKnownLength(t *testing.T) {
+	page := bytes.Repeat([]byte("<p>index</p>\n"), 1<<16)
+	h := HeadAsGet(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("handler got %s, want GET", r.Method)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+		w.WriteHeader(http.StatusOK)
+		// Handlers that check for errors, like ReverseProxy, must not see one
+		if n, err := w.Write(page); n != len(page) || err != nil {
+			t.Errorf("Write = %d, %v, want %d, nil", n, err, len(page))
+		}
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("HEAD", "/index.html", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("got %d with %d body bytes, want 200 and no body", rec.Code, rec.Body.Len())
+	}
+	if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(len(page)) {
+		t.Errorf("Content-Length = %s, want %d", got, len(page))
+	}
+}
+
+func TestHeadAsGetCompressed(t *testing.T) {
+	h := HeadAsGet(Compress([]string{"gzip"}, taggedPage))
+	get := httptest.NewRequest("GET", "/index.html", nil)
+	get.Header.Set("Accept-Encoding", "gzip")
+	want := httptest.NewRecorder()
+	h.ServeHTTP(want, get)
+
+	head := httptest.NewRequest("HEAD", "/index.html", nil)
+	head.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, head)
+
+	if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(want.Body.Len()) {
+		t.Errorf("Content-Length = %s, want the %d bytes GET sends", got, want.Body.Len())
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Body.Len() != 0 {
+		t.Errorf("got Content-Encoding %q and %d body bytes, want gzip and no body", rec.Header().Get("Content-Encoding"), rec.Body.Len())
+	}
+}
+
+func TestSniffExtensionless(t *testing.T) {
+	root := http.FS(fstest.MapFS{
+		"README":   {Data: []byte("Build with bun run build, then serve dist/.\n")},
+		"data":     {Data: []byte{0x00, 0x01, 0x02, 0xff, 0xfe, 0x10, 0x20}},
+		"manifest": {Data: []byte(`  {"name": "app", "icons": []}`)},
+		"page":     {Data: []byte("<!doctype html><title>page</title>")},
+		"notes":    {Data: []byte("[draft] not actually JSON")},
+	})
+	h := SniffExtensionless(root, http.FileServer(root))
+
+	for name, want := range map[string]string{
+		"README":   "text/plain; charset=utf-8",
+		"data":     "application/octet-stream",
+		"manifest": "application/json",
+		"page":     "text/html; charset=utf-8",
+		"notes":    "text/plain; charset=utf-8",
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/"+name, nil))
+		if got := rec.Header().Get("Content-Type"); got != want {
+			t.Errorf("/%s: Content-Type = %q, want %q", name, got, want)
+		}
+	}
+
+	// A Range request is left to FileServer's own sniffing
+	req := httptest.NewRequest("GET", "/manifest", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent {
+		t.Errorf("Range: status = %d, want 206", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Range: Content-Type = %q, want FileServer's", got)
+	}
+}
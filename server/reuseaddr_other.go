@@ -0,0 +1,11 @@
+//go:build !unix
+
+package server
+
+import "syscall"
+
+// reuseAddr does nothing: TIME_WAIT doesn't block rebinding on Windows, and
+// SO_REUSEADDR there would let two servers share a port.
+func reuseAddr(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,334 @@
+// Package server is dapptoon's file server without the tray, QR codes, or
+// LAN discovery, for serving a front-end build from another Go program:
+//
+//	srv := server.New(server.Options{FS: dist, Port: 8080, SPA: true, Gzip: true})
+//	if err := srv.Start(); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer srv.Shutdown(context.Background())
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Options configures a Server. The zero value serves nothing on a free port.
+type Options struct {
+	// FS is the build to serve, with index.html at its root.
+	FS fs.FS
+	// Handler, when set, is served instead of FS and the middleware
+	// options below, for callers that build their own chain.
+	Handler http.Handler
+
+	// Bind is the address to listen on; empty means all interfaces.
+	Bind string
+	// Port is the port to listen on; 0 picks a free one.
+	Port int
+	// BindRetries is how many more times to try binding Port while it's in
+	// use, with doubling waits from 100ms, for the previous run of a rapid
+	// restart to let go of it.
+	BindRetries int
+	// FallbackPort picks a free port when Port is still in use after the
+	// retries, instead of failing.
+	FallbackPort bool
+	// UnixSocket, when set, is a socket path to listen on instead of a TCP
+	// port, for a reverse proxy on the same machine.
+	UnixSocket string
+	// TLSCert, when set, makes the server speak HTTPS.
+	TLSCert *tls.Certificate
+	// H2C also speaks HTTP/2 without TLS, to clients that start with it
+	// ("prior knowledge"), like curl --http2-prior-knowledge. Browsers
+	// only use HTTP/2 over TLS.
+	H2C bool
+	// DualStack listens with separate IPv4 and IPv6 sockets on the same
+	// port when Bind is empty, instead of one socket for both, which some
+	// systems don't allow (IPV6_V6ONLY). Either family may be missing.
+	DualStack bool
+
+	// Index is the page served for folders; empty means index.html.
+	Index string
+	// SPA serves the index page for client-side routes with no matching
+	// file.
+	SPA bool
+	// CachePattern matches fingerprinted asset paths, which are cached
+	// forever; nil uses DefaultHashPattern.
+	CachePattern *regexp.Regexp
+	// Brotli and Gzip compress text responses, preferring Brotli.
+	Brotli, Gzip bool
+	// Precompressed serves .br and .gz siblings the build emitted.
+	Precompressed bool
+	// Auth requires HTTP Basic credentials given as "user:pass".
+	Auth string
+	// CORS lists the origins allowed to fetch from the server, or "*".
+	CORS []string
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+}
+
+// Server serves one build on one port.
+type Server struct {
+	opts  Options
+	http  *http.Server
+	port  int
+	addrs []net.Addr
+	done  chan error
+}
+
+// New returns a server for opts; call Start to begin serving.
+func New(opts Options) *Server {
+	handler := opts.Handler
+	if handler == nil {
+		handler = newHandler(opts)
+	}
+	s := &Server{
+		opts: opts,
+		http: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: opts.ReadHeaderTimeout,
+			ReadTimeout:       opts.ReadTimeout,
+			WriteTimeout:      opts.WriteTimeout,
+			IdleTimeout:       opts.IdleTimeout,
+		},
+		done: make(chan error, 1),
+	}
+	if opts.H2C {
+		s.http.Protocols = new(http.Protocols)
+		s.http.Protocols.SetHTTP1(true)
+		s.http.Protocols.SetHTTP2(true)
+		s.http.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return s
+}
+
+// newHandler builds the middleware chain the options ask for around FS.
+func newHandler(opts Options) http.Handler {
+	if opts.FS == nil {
+		return http.NotFoundHandler()
+	}
+	root := http.FS(opts.FS)
+	var handler http.Handler = SniffExtensionless(root, http.FileServer(root))
+	if opts.Precompressed {
+		handler = Precompressed(root, handler)
+	}
+	if opts.Index != "" && opts.Index != "index.html" {
+		handler = IndexFile(root, opts.Index, handler)
+	}
+	if opts.SPA {
+		handler = SPAFallback(root, handler)
+	}
+	pattern := opts.CachePattern
+	if pattern == nil {
+		pattern = regexp.MustCompile(DefaultHashPattern)
+	}
+	handler = CacheHeaders(pattern, handler)
+
+	var encodings []string
+	if opts.Brotli {
+		encodings = append(encodings, "br")
+	}
+	if opts.Gzip {
+		encodings = append(encodings, "gzip")
+	}
+	if len(encodings) > 0 {
+		handler = Compress(encodings, handler)
+	}
+	handler = HeadAsGet(handler)
+	if opts.Auth != "" {
+		user, pass, _ := strings.Cut(opts.Auth, ":")
+		handler = BasicAuth(user, pass, handler)
+	}
+	// Outside auth, since browsers send preflights without credentials
+	if len(opts.CORS) > 0 {
+		handler = CORS(opts.CORS, handler)
+	}
+	return Recover(func() http.FileSystem { return root }, handler)
+}
+
+// Start binds the port and serves in the background. Binding happens before
+// it returns, so errors like a privileged port surface right away.
+func (s *Server) Start() error {
+	var lns []net.Listener
+	var err error
+	switch {
+	case s.opts.UnixSocket != "":
+		var ln net.Listener
+		ln, err = listenUnix(s.opts.UnixSocket)
+		lns = []net.Listener{ln}
+	case s.opts.DualStack && s.opts.Bind == "":
+		lns, err = s.listenDualStack()
+	default:
+		var ln net.Listener
+		ln, err = s.listenTCP("tcp", s.opts.Port, s.opts.FallbackPort)
+		lns = []net.Listener{ln}
+	}
+	if err != nil {
+		return err
+	}
+	for _, ln := range lns {
+		s.addrs = append(s.addrs, ln.Addr())
+	}
+
+	if s.opts.TLSCert != nil {
+		s.http.TLSConfig = &tls.Config{Certificates: []tls.Certificate{*s.opts.TLSCert}}
+	}
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() {
+			var err error
+			if s.opts.TLSCert != nil {
+				err = s.http.ServeTLS(ln, "", "")
+			} else {
+				err = s.http.Serve(ln)
+			}
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+			errs <- err
+		}()
+	}
+	// The server has stopped once every listener has, or failed once one has
+	go func() {
+		var err error
+		for range lns {
+			if err = <-errs; err != nil {
+				break
+			}
+		}
+		s.done <- err
+	}()
+	return nil
+}
+
+// listenDualStack binds the port with separate IPv4 and IPv6 listeners,
+// the second on the port the first got. A family the machine doesn't have
+// is skipped; only when neither can be bound does it fail.
+func (s *Server) listenDualStack() ([]net.Listener, error) {
+	ln4, err4 := s.listenTCP("tcp4", s.opts.Port, s.opts.FallbackPort)
+	if err4 != nil && isAddrInUse(err4) {
+		return nil, err4
+	}
+	if err4 != nil {
+		ln6, err := s.listenTCP("tcp6", s.opts.Port, s.opts.FallbackPort)
+		if err != nil {
+			return nil, err4
+		}
+		return []net.Listener{ln6}, nil
+	}
+	ln6, err := s.listenTCP("tcp6", s.port, false)
+	if err != nil {
+		return []net.Listener{ln4}, nil
+	}
+	return []net.Listener{ln4, ln6}, nil
+}
+
+// listenTCP binds port on network ("tcp", "tcp4", or "tcp6"), retrying and,
+// with fallback, picking a free port as configured.
+func (s *Server) listenTCP(network string, port int, fallback bool) (net.Listener, error) {
+	lc := net.ListenConfig{Control: reuseAddr}
+	addr := net.JoinHostPort(s.opts.Bind, strconv.Itoa(port))
+	ln, err := lc.Listen(context.Background(), network, addr)
+	wait := 100 * time.Millisecond
+	for i := 0; i < s.opts.BindRetries && err != nil && isAddrInUse(err); i++ {
+		time.Sleep(wait)
+		wait *= 2
+		ln, err = lc.Listen(context.Background(), network, addr)
+	}
+	if err != nil && fallback && isAddrInUse(err) {
+		ln, err = lc.Listen(context.Background(), network, net.JoinHostPort(s.opts.Bind, "0"))
+	}
+	if err != nil {
+		if isAddrInUse(err) && s.opts.BindRetries > 0 {
+			return nil, fmt.Errorf("port %d is still in use after %d retries: %w", port, s.opts.BindRetries, err)
+		}
+		return nil, fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+	s.port = ln.Addr().(*net.TCPAddr).Port
+	return ln, nil
+}
+
+// listenUnix listens on a socket at path, first removing a socket a run
+// that didn't shut down cleanly left behind. Anything else at path is left
+// alone. The listener removes the socket again when the server stops.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	return ln, nil
+}
+
+// isAddrInUse reports whether err is a failed bind on an occupied port.
+func isAddrInUse(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	// WSAEADDRINUSE is what Windows reports instead of EADDRINUSE
+	return errno == syscall.EADDRINUSE || (runtime.GOOS == "windows" && errno == 10048)
+}
+
+// Port returns the port the server is listening on, which differs from
+// Options.Port when that was 0 or taken, or 0 on a Unix socket.
+func (s *Server) Port() int {
+	return s.port
+}
+
+// Addr returns the address the server is listening on, e.g. to send
+// requests to one started on 127.0.0.1 port 0, or nil before Start. With
+// DualStack it's the first of Addrs.
+func (s *Server) Addr() net.Addr {
+	if len(s.addrs) == 0 {
+		return nil
+	}
+	return s.addrs[0]
+}
+
+// Addrs returns every address the server is listening on: two with
+// DualStack when the machine has both families, otherwise one.
+func (s *Server) Addrs() []net.Addr {
+	return s.addrs
+}
+
+// Wait blocks until the server stops, returning why if it wasn't Shutdown
+// or Close.
+func (s *Server) Wait() error {
+	err := <-s.done
+	s.done <- err
+	return err
+}
+
+// RegisterOnShutdown calls f when Shutdown starts, e.g. to end long-lived
+// streams that would otherwise keep it waiting.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.http.RegisterOnShutdown(f)
+}
+
+// Shutdown stops the server gracefully, waiting for open requests until ctx
+// is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
+
+// Close stops the server immediately, dropping open connections.
+func (s *Server) Close() error {
+	return s.http.Close()
+}
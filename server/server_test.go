@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"testing/fstest"
+)
+
+var testBuild = fstest.MapFS{
+	"index.html":    {Data: []byte("<html><body>app</body></html>")},
+	"assets/app.js": {Data: []byte("console.log('app')")},
+}
+
+// startTest starts a server for opts on a free loopback port and returns
+// its base URL, shutting it down when the test ends.
+func startTest(t *testing.T, opts Options) string {
+	t.Helper()
+	opts.Bind, opts.Port = "127.0.0.1", 0
+	srv := New(opts)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+		if err := srv.Wait(); err != nil {
+			t.Error(err)
+		}
+	})
+	return "http://" + srv.Addr().String()
+}
+
+// get fetches url and returns the response's status and body.
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestServerServesFS(t *testing.T) {
+	base := startTest(t, Options{FS: testBuild})
+
+	if status, body := get(t, base+"/"); status != http.StatusOK || body != "<html><body>app</body></html>" {
+		t.Errorf("GET / = %d %q, want the index page", status, body)
+	}
+	if status, body := get(t, base+"/assets/app.js"); status != http.StatusOK || body != "console.log('app')" {
+		t.Errorf("GET /assets/app.js = %d %q, want the script", status, body)
+	}
+	if status, _ := get(t, base+"/settings"); status != http.StatusNotFound {
+		t.Errorf("GET /settings = %d, want 404 without SPA", status)
+	}
+}
+
+func TestServerSPA(t *testing.T) {
+	base := startTest(t, Options{FS: testBuild, SPA: true})
+
+	if status, body := get(t, base+"/settings/profile"); status != http.StatusOK || body != "<html><body>app</body></html>" {
+		t.Errorf("GET /settings/profile = %d %q, want the index page", status, body)
+	}
+	if status, _ := get(t, base+"/assets/missing.js"); status != http.StatusNotFound {
+		t.Errorf("GET /assets/missing.js = %d, want 404 for a missing asset", status)
+	}
+}
+
+func TestServerAddrBeforeStart(t *testing.T) {
+	srv := New(Options{FS: testBuild})
+	if srv.Addr() != nil {
+		t.Errorf("Addr() = %v before Start, want nil", srv.Addr())
+	}
+}
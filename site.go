@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mstenq/dapptoon/server"
+)
+
+// servedDir is a folder given with -dir, optionally named as name=path.
+type servedDir struct {
+	Name string
+	Path string
+}
+
+// parseServedDir accepts "name=path" or a bare path, which is named after
+// its last element. -archive files are named the same way.
+func parseServedDir(v string) servedDir {
+	if name, path, ok := strings.Cut(v, "="); ok && name != "" {
+		return servedDir{Name: name, Path: path}
+	}
+	return servedDir{Name: filepath.Base(v), Path: v}
+}
+
+// modernTypes are extensions the OS MIME tables often lack or get wrong;
+// browsers refuse modules and streaming wasm served with the wrong type.
+var modernTypes = map[string]string{
+	".mjs":         "text/javascript; charset=utf-8",
+	".wasm":        "application/wasm",
+	".webmanifest": "application/manifest+json",
+	".avif":        "image/avif",
+}
+
+// registerMIMETypes adds modernTypes to the mime package's table, which
+// FileServer consults for Content-Type.
+func registerMIMETypes() {
+	for ext, typ := range modernTypes {
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			log.Printf("Failed to register MIME type for %s: %v", ext, err)
+		}
+	}
+}
+
+// site is one servable front-end build: the embedded dist or a folder.
+type site struct {
+	name    string
+	dir     string // empty for the embedded build and archives
+	root    http.FileSystem
+	handler http.Handler
+	// hashes are the content hashes of an embedded build's files
+	hashes map[string]string
+}
+
+// newSite serves root as a single-page app, with -listing as a browsable
+// file share, or with -strict-paths as plain files.
+func newSite(name, dir string, root http.FileSystem) *site {
+	if cfg.StrictPaths {
+		return &site{name: name, dir: dir, root: root, handler: exactFiles(root)}
+	}
+	if cfg.Listing {
+		return &site{name: name, dir: dir, root: root, handler: dirListing(root, fileServer(root))}
+	}
+	routes := routed(root)
+	return &site{name: name, dir: dir, root: root, handler: server.SPAFallback(routes, localized(routes, fileServer(routes)))}
+}
+
+// confinedDir is a folder that, for -no-symlinks, refuses paths whose
+// symlinks lead outside it, so a link to ~/.ssh in a shared folder can't be
+// followed from another device.
+type confinedDir struct {
+	http.Dir
+	// real is the folder with its own symlinks resolved
+	real string
+}
+
+func newConfinedDir(dir string) (confinedDir, error) {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return confinedDir{}, err
+	}
+	real, err = filepath.Abs(real)
+	return confinedDir{Dir: http.Dir(dir), real: real}, err
+}
+
+// Open refuses name with fs.ErrPermission, which the file server answers
+// with 403, when it resolves outside the folder.
+func (d confinedDir) Open(name string) (http.File, error) {
+	// The same path http.Dir opens
+	full := filepath.Join(string(d.Dir), filepath.FromSlash(path.Clean("/"+name)))
+	if resolved, err := filepath.EvalSymlinks(full); err == nil {
+		rel, err := filepath.Rel(d.real, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fs.ErrPermission
+		}
+	}
+	return d.Dir.Open(name)
+}
+
+// routed is root as the app's routes see it: with -no-dir-redirect its
+// folders are hidden, so the SPA fallback answers for them.
+func routed(root http.FileSystem) http.FileSystem {
+	if cfg.NoDirRedirect {
+		return server.HideDirs(root)
+	}
+	return root
+}
+
+// localized picks the root page by language when -index-lang is set.
+func localized(root http.FileSystem, next http.Handler) http.Handler {
+	if len(cfg.LangIndexes) == 0 {
+		return next
+	}
+	return langIndex(root, cfg.LangIndexes, next)
+}
+
+// fileServer serves the files in root, with -index as the folder page and
+// precompressed siblings in place of the files they compress.
+func fileServer(root http.FileSystem) http.Handler {
+	files := server.SniffExtensionless(root, http.FileServer(root))
+	if cfg.Precompressed {
+		files = server.Precompressed(root, files)
+	}
+	if cfg.Index == "index.html" {
+		return files
+	}
+	return server.IndexFile(root, cfg.Index, files)
+}
+
+// exactFiles serves root for -strict-paths, each URL being one file.
+func exactFiles(root http.FileSystem) http.Handler {
+	files := server.SniffExtensionless(root, server.ExactPaths(root))
+	if !cfg.Precompressed {
+		return files
+	}
+	precompressed := server.Precompressed(root, files)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Folders have no index page here, compressed or not
+		if strings.HasSuffix(r.URL.Path, "/") {
+			files.ServeHTTP(w, r)
+			return
+		}
+		precompressed.ServeHTTP(w, r)
+	})
+}
+
+// errNoBuild is why a -strict run has nothing to serve, which exits with
+// exitNoBuild so CI can tell a binary built without the front-end from a
+// bad flag (2) or any other failure (1).
+var errNoBuild = errors.New("front-end build missing")
+
+const exitNoBuild = 3
+
+// loadSites builds a site per -app build, -dir folder, and -archive file,
+// or the embedded build when none were given.
+func loadSites(apps []string, dirs, archives []servedDir) ([]*site, error) {
+	if len(apps) == 0 && len(dirs) == 0 && len(archives) == 0 {
+		// Get the embedded dist subdirectory
+		distFS, err := fs.Sub(distFiles, "dist")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dist subdirectory: %w", err)
+		}
+		s := embeddedSite("embedded", distFS)
+		// Building without the front-end first embeds no app, and every page
+		// would silently 404
+		if _, err := fs.Stat(distFS, cfg.Index); err != nil {
+			if cfg.Strict {
+				return nil, fmt.Errorf("%w: the embedded dist has no %s and no -dir, -archive, or -app was given; run `bun run build` before `go build`", errNoBuild, cfg.Index)
+			}
+			warnf("WARNING: the embedded dist has no %s; run `bun run build` before `go build`, or serve a folder with -dir", cfg.Index)
+			s.handler = http.HandlerFunc(serveMissingBuild)
+		}
+		return []*site{s}, nil
+	}
+
+	var sites []*site
+	for _, name := range apps {
+		if info, err := fs.Stat(distFiles, path.Join("dist", name)); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("cannot serve -app %q: no dist/%s folder in the embedded build", name, name)
+		}
+		fsys, err := fs.Sub(distFiles, path.Join("dist", name))
+		if err != nil {
+			return nil, fmt.Errorf("cannot serve -app %q: %w", name, err)
+		}
+		if _, err := fs.Stat(fsys, cfg.Index); err != nil {
+			return nil, fmt.Errorf("cannot serve -app %q: dist/%s has no %s", name, name, cfg.Index)
+		}
+		sites = append(sites, embeddedSite(name, fsys))
+	}
+	for _, d := range dirs {
+		if info, err := os.Stat(d.Path); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("cannot serve %q: not a directory", d.Path)
+		}
+		var root http.FileSystem = http.Dir(d.Path)
+		if cfg.NoSymlinks {
+			confined, err := newConfinedDir(d.Path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot serve %q: %w", d.Path, err)
+			}
+			root = confined
+		}
+		sites = append(sites, newSite(d.Name, d.Path, root))
+	}
+	for _, a := range archives {
+		fsys, err := openArchive(a.Path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot serve %q: %w", a.Path, err)
+		}
+		// Archives have no folder on disk to show or watch
+		sites = append(sites, newSite(a.Name, "", http.FS(fsys)))
+	}
+	return sites, nil
+}
+
+// shippedSite returns the embedded build as a site, or nil if the binary
+// was built without one.
+func shippedSite() *site {
+	distFS, err := fs.Sub(distFiles, "dist")
+	if err != nil {
+		return nil
+	}
+	if _, err := fs.Stat(distFS, cfg.Index); err != nil {
+		return nil
+	}
+	return embeddedSite("embedded", distFS)
+}
+
+// embeddedSite serves a build embedded in the binary, which can be hashed
+// once up front since it never changes.
+func embeddedSite(name string, fsys fs.FS) *site {
+	root := http.FS(fsys)
+	s := &site{name: name, root: root, hashes: hashAssets(fsys)}
+	if cfg.StrictPaths {
+		var files http.Handler = embeddedETags(s.hashes, exactFiles(root))
+		if cfg.AssetVersions {
+			files = versionedAssets(fsys, s.hashes, files)
+		}
+		s.handler = files
+		return s
+	}
+	routes := routed(root)
+	var files http.Handler = embeddedETags(s.hashes, fileServer(routes))
+	if cfg.AssetVersions {
+		files = versionedAssets(fsys, s.hashes, files)
+	}
+	s.handler = server.SPAFallback(routes, localized(routes, files))
+	return s
+}
+
+var (
+	sites []*site
+
+	// shipped is the embedded build, loaded alongside -dir folders so the
+	// tray can switch to it for comparison; nil when there's none.
+	shipped *site
+
+	// activeSite is the site being served; swapping it switches folders
+	// instantly without touching the listener.
+	activeSite atomic.Pointer[site]
+)
+
+// serveActiveSite dispatches to whichever site is active at request time.
+func serveActiveSite(w http.ResponseWriter, r *http.Request) {
+	activeSite.Load().handler.ServeHTTP(w, r)
+}
+
+// missingBuildPage explains the blank app when the binary was built without
+// the front-end.
+const missingBuildPage = `<!doctype html>
+<html>
+<head><meta charset="utf-8"><title>Dapptoon: front-end build missing</title></head>
+<body style="font-family: system-ui, sans-serif; max-width: 40em; margin: 4em auto; line-height: 1.5">
+<h1>Front-end build missing</h1>
+<p>This dapptoon binary was built without the React app, so there is nothing to serve.</p>
+<p>Run <code>bun run build</code> to fill <code>dist/</code>, then rebuild with <code>go build</code>.
+Or serve an existing build with <code>-dir path/to/dist</code>.</p>
+</body>
+</html>
+`
+
+func serveMissingBuild(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(missingBuildPage))
+	}
+}
+
+// embeddedETags sets a content-hash ETag on embedded files, which also
+// makes FileServer answer If-None-Match with 304. The files carry no
+// modification time, so without one it also can't honour If-Range and
+// answers resumed range requests (as Safari sends for media) with the whole
+// file.
+func embeddedETags(hashes map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		hash, ok := hashes[name]
+		if !ok {
+			// A folder, served as its index page
+			hash, ok = hashes[path.Join(name, cfg.Index)]
+		}
+		if ok {
+			w.Header().Set("ETag", `"`+hash+`"`)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// faviconFallback serves the tray icon for /favicon.ico when the active
+// site doesn't have its own, so the browser tab isn't blank.
+func faviconFallback(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f, err := activeSite.Load().root.Open("/favicon.ico"); err == nil {
+			f.Close()
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		http.ServeContent(w, r, "favicon.png", time.Time{}, bytes.NewReader(iconData))
+	})
+}
+
+// embeddedBuildVersion reads the version the front-end build step recorded
+// in dist, from version.txt or the "version" field of build-info.json, so
+// it's clear which build a binary carries.
+func embeddedBuildVersion() string {
+	if data, err := distFiles.ReadFile("dist/version.txt"); err == nil {
+		if v := strings.TrimSpace(string(data)); v != "" {
+			return v
+		}
+	}
+	if data, err := distFiles.ReadFile("dist/build-info.json"); err == nil {
+		var info struct {
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(data, &info) == nil && info.Version != "" {
+			return info.Version
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+var testBuild = fstest.MapFS{
+	"index.html":      {Data: []byte("<html><body>app</body></html>")},
+	"media/intro.mp4": {Data: []byte("0123456789abcdef")},
+}
+
+func TestModernMIMETypes(t *testing.T) {
+	testConfig(t, nil)
+	registerMIMETypes()
+	dir := testDir(t, map[string]string{
+		"index.html":        "<html></html>",
+		"app.mjs":           "export default 1",
+		"app.wasm":          "\x00asm",
+		"site.webmanifest":  "{}",
+		"images/cover.avif": "avif",
+	})
+	h := newSite("test", dir, http.Dir(dir)).handler
+
+	for name, want := range map[string]string{
+		"/app.mjs":           "text/javascript; charset=utf-8",
+		"/app.wasm":          "application/wasm",
+		"/site.webmanifest":  "application/manifest+json",
+		"/images/cover.avif": "image/avif",
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", name, nil))
+		if got := rec.Header().Get("Content-Type"); got != want {
+			t.Errorf("%s: Content-Type = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestEmbeddedRange(t *testing.T) {
+	testConfig(t, nil)
+	h := embeddedSite("embedded", testBuild).handler
+
+	req := httptest.NewRequest("GET", "/media/intro.mp4", nil)
+	req.Header.Set("Range", "bytes=4-7")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "4567" {
+		t.Fatalf("Range: got %d %q, want 206 \"4567\"", rec.Code, rec.Body.String())
+	}
+
+	// Resuming with the tag it got, as Safari does for media
+	tag := rec.Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("no ETag for If-Range to send")
+	}
+	req.Header.Set("If-Range", tag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "4567" {
+		t.Errorf("If-Range: got %d %q, want 206 \"4567\"", rec.Code, rec.Body.String())
+	}
+}
+
+func TestEmbeddedETagNotModified(t *testing.T) {
+	testConfig(t, nil)
+	h := embeddedSite("embedded", testBuild).handler
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/media/intro.mp4", nil))
+	tag := rec.Header().Get("ETag")
+	if want := `"` + hashAssets(testBuild)["media/intro.mp4"] + `"`; tag != want {
+		t.Fatalf("ETag = %q, want the content hash %s", tag, want)
+	}
+
+	req := httptest.NewRequest("GET", "/media/intro.mp4", nil)
+	req.Header.Set("If-None-Match", tag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("got %d with %d body bytes, want 304", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestPrecompressedIndex(t *testing.T) {
+	testConfig(t, func(c *config) { c.Precompressed = true })
+	dir := testDir(t, map[string]string{
+		"index.html":    "<html><body>app</body></html>",
+		"index.html.br": "brotli bytes",
+	})
+	h := newSite("test", dir, http.Dir(dir)).handler
+
+	// The root and a client-side route are both the index page
+	for _, path := range []string{"/", "/settings/profile"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "br")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Header().Get("Content-Encoding") != "br" || rec.Body.String() != "brotli bytes" {
+			t.Errorf("%s: got Content-Encoding %q and %q, want index.html.br", path, rec.Header().Get("Content-Encoding"), rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+			t.Errorf("%s: Content-Type = %q, want HTML", path, got)
+		}
+	}
+
+	// With -strict-paths a folder still has no page
+	cfg.StrictPaths = true
+	h = newSite("test", dir, http.Dir(dir)).handler
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("-strict-paths /: status = %d, want 404", rec.Code)
+	}
+}
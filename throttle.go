@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// throttle keeps a token bucket of response bytes per connection, for
+// -throttle to mimic a slow mobile network without outside tools.
+type throttle struct {
+	mu    sync.Mutex
+	conns map[string]*throttledConn
+	limit rate.Limit
+	burst int
+}
+
+type throttledConn struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newThrottle(bytesPerSecond int64) *throttle {
+	// Small enough chunks that the bytes trickle out rather than arriving
+	// in one lump a second
+	burst := int(min(bytesPerSecond, 16<<10))
+	return &throttle{conns: make(map[string]*throttledConn), limit: rate.Limit(bytesPerSecond), burst: burst}
+}
+
+// limiter returns the bucket for the connection addr, a client's IP and
+// port, which requests kept alive on it share.
+func (t *throttle) limiter(addr string) *rate.Limiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	c, found := t.conns[addr]
+	if !found {
+		// Prune idle connections only when adding one, like rateLimiter
+		for k, v := range t.conns {
+			if now.Sub(v.lastSeen) >= idleClientTTL {
+				delete(t.conns, k)
+			}
+		}
+		c = &throttledConn{limiter: rate.NewLimiter(t.limit, t.burst)}
+		t.conns[addr] = c
+	}
+	c.lastSeen = now
+	return c.limiter
+}
+
+// middleware slows every response body to the throttle's rate. It applies
+// to loopback too, since testing on this computer is the point.
+func (t *throttle) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A slowed download can easily outlast -write-timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		next.ServeHTTP(&throttledWriter{ResponseWriter: w, r: r, limiter: t.limiter(r.RemoteAddr), chunk: t.burst}, r)
+	})
+}
+
+// throttledWriter writes the body a chunk at a time, waiting for the
+// bucket to allow each.
+type throttledWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	limiter *rate.Limiter
+	chunk   int
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), w.chunk)
+		if err := w.limiter.WaitN(w.r.Context(), n); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
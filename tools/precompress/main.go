@@ -0,0 +1,95 @@
+// Command precompress writes .br and .gz siblings next to the text assets in
+// a front-end build, so the server can send them as they are instead of
+// compressing on every request. It runs from go:generate before the build
+// is embedded:
+//
+//	go run ./tools/precompress dist
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// textExts are the assets worth compressing; images other than SVG, fonts,
+// and media are already compressed.
+var textExts = map[string]bool{
+	".html": true, ".css": true, ".js": true, ".mjs": true, ".json": true, ".map": true,
+	".svg": true, ".txt": true, ".xml": true, ".wasm": true, ".webmanifest": true,
+}
+
+// minSize is the smallest file compressed; below it the savings don't
+// cover the extra files.
+const minSize = 1024
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: precompress <dir>")
+		os.Exit(2)
+	}
+	var files, saved int
+	err := filepath.WalkDir(os.Args[1], func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !textExts[strings.ToLower(filepath.Ext(path))] {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || len(data) < minSize {
+			return err
+		}
+		for _, enc := range []struct {
+			ext      string
+			compress func([]byte) ([]byte, error)
+		}{{".br", compressBrotli}, {".gz", compressGzip}} {
+			out, err := enc.compress(data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			// A sibling that isn't smaller would only cost bytes
+			if len(out) >= len(data) {
+				os.Remove(path + enc.ext)
+				continue
+			}
+			if err := os.WriteFile(path+enc.ext, out, 0644); err != nil {
+				return err
+			}
+			saved += len(data) - len(out)
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "precompress:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("precompress: %d files in %s, %d KB saved across encodings\n", files, os.Args[1], saved/1024)
+}
+
+func compressBrotli(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w := brotli.NewWriterLevel(&b, brotli.BestCompression)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	err := w.Close()
+	return b.Bytes(), err
+}
+
+func compressGzip(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return b.Bytes(), err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// trayReady is closed once onReady runs, which means the tray started.
+var trayReady = make(chan struct{})
+
+// desktopAvailable reports whether there's a desktop session for the tray
+// to appear in. Only X11 and Wayland systems can lack one; without it the
+// tray can't start at all.
+func desktopAvailable() bool {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return true
+	}
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// watchTrayReady points the user at the URL when the tray doesn't come up
+// within timeout, or when it starts on a Linux desktop with nothing to show
+// it, so an invisible icon isn't mistaken for a dead app.
+func watchTrayReady(timeout time.Duration) {
+	url := cmp.Or(shareURL(), localURL)
+	select {
+	case <-trayReady:
+		if missing := trayHostMissing(); missing {
+			log.Printf("WARNING: this desktop has no system tray host (StatusNotifierWatcher), so the tray icon may not be visible. The server is running at %s; use -no-tray to run headless", url)
+		}
+	case <-time.After(timeout):
+		log.Printf("WARNING: the tray icon hasn't appeared after %s. The server is still running at %s; if this desktop has no system tray, run with -no-tray and stop it with Ctrl-C", timeout, url)
+	}
+}
+
+// trayHostMissing asks D-Bus whether a StatusNotifier host, which Linux tray
+// icons need, is running. It errs towards false when it can't tell.
+func trayHostMissing() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	out, err := exec.Command("dbus-send", "--session", "--dest=org.freedesktop.DBus", "--type=method_call", "--print-reply",
+		"/org/freedesktop/DBus", "org.freedesktop.DBus.NameHasOwner", "string:org.kde.StatusNotifierWatcher").Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(out), "boolean false")
+}
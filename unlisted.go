@@ -0,0 +1,21 @@
+package main
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// newShareToken returns a random -unlisted token, long enough that nobody
+// on the LAN will guess it.
+func newShareToken() string {
+	b := make([]byte, 10)
+	rand.Read(b)
+	return requestIDEncoding.EncodeToString(b)
+}
+
+// validShareToken reports whether a -token can go in a URL path as it is.
+func validShareToken(token string) bool {
+	return token != "" && len(token) <= 64 && !strings.ContainsFunc(token, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_')
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the release this binary was built as, set when packaging with
+// -ldflags "-X main.version=v1.4.0".
+var version string
+
+// appVersion returns the release version, falling back to the module
+// version go install records, or "dev" for a local build.
+func appVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// latestReleaseURL is the GitHub API endpoint for the newest release.
+const latestReleaseURL = "https://api.github.com/repos/mstenq/dapptoon/releases/latest"
+
+// checkUpdate tells the user, in the log and a notification, when a newer
+// release than this binary is out. It only informs; nothing is downloaded.
+// Errors are ignored, so running offline is no different.
+func checkUpdate() {
+	current := appVersion()
+	if parseVersion(current) == nil {
+		// A development build has nothing to compare against
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, latestReleaseURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "dapptoon/"+current)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&release) != nil {
+		return
+	}
+	if newerVersion(release.TagName, current) {
+		msg := fmt.Sprintf("Dapptoon %s is available (this is %s): %s", release.TagName, current, release.HTMLURL)
+		logEvent("update_available", msg, "version", release.TagName, "current", current, "url", release.HTMLURL)
+		notify(msg)
+	}
+}
+
+// parseVersion splits "v1.4.0" into its numbers, ignoring a pre-release or
+// build suffix, or returns nil if v isn't a version.
+func parseVersion(v string) []int {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// newerVersion reports whether latest is a later release than current.
+func newerVersion(latest, current string) bool {
+	l, c := parseVersion(latest), parseVersion(current)
+	if l == nil || c == nil {
+		return false
+	}
+	for i := 0; i < max(len(l), len(c)); i++ {
+		var a, b int
+		if i < len(l) {
+			a = l[i]
+		}
+		if i < len(c) {
+			b = c[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// uploadPage is the form -uploads serves at /upload, with the outcome of
+// the last upload.
+var uploadPage = template.Must(template.New("upload").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Send files</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 32em; margin: 3em auto; padding: 0 1em; color: #222; }
+input, button { font-size: 1.1em; margin: 0.5em 0; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Send files to this computer</h1>
+{{range .Saved}}<p>Saved {{.}}</p>
+{{end}}{{with .Error}}<p class="error">{{.}}</p>
+{{end}}<form method="post" enctype="multipart/form-data">
+<input type="file" name="file" multiple required><br>
+<button>Upload</button>
+</form>
+</body>
+</html>
+`))
+
+// uploadHandler serves the upload form and saves files posted to it into
+// dir. Bodies over -max-body are refused like any other request.
+func uploadHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result struct {
+			Saved []string
+			Error string
+		}
+		status := http.StatusOK
+		if r.Method == http.MethodPost {
+			saved, err := saveUploads(dir, r)
+			result.Saved = saved
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				status = http.StatusBadRequest
+				if errors.As(err, &tooLarge) {
+					status = http.StatusRequestEntityTooLarge
+				}
+				result.Error = err.Error()
+				log.Printf("Upload from %s failed: %v", clientIP(r), err)
+			}
+			for _, name := range saved {
+				logEvent("upload", fmt.Sprintf("Received %s from %s", name, clientIP(r)), "file", name, "remote", clientIP(r).String())
+			}
+			if len(saved) > 0 {
+				notify(fmt.Sprintf("Received %s from %s", strings.Join(saved, ", "), clientIP(r)))
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		uploadPage.Execute(w, result)
+	})
+}
+
+// saveUploads streams each file part of r into dir, returning the names
+// saved, which may differ from the ones sent to avoid overwriting.
+func saveUploads(dir string, r *http.Request) ([]string, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var saved []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return saved, nil
+		}
+		if err != nil {
+			return saved, err
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			continue
+		}
+		name, err := uploadName(part.FileName())
+		if err != nil {
+			return saved, err
+		}
+		name, err = saveUpload(dir, name, part)
+		if err != nil {
+			return saved, err
+		}
+		saved = append(saved, name)
+	}
+}
+
+// uploadName reduces a client's file name to a plain name inside the
+// upload folder, refusing names that would leave it.
+func uploadName(sent string) (string, error) {
+	// Windows browsers may send the full path, with either separator
+	name := sent[strings.LastIndexAny(sent, `/\`)+1:]
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid file name %q", sent)
+	}
+	return name, nil
+}
+
+// saveUpload writes src to name in dir, adding " (2)", " (3)", and so on
+// before the extension instead of replacing an existing file.
+func saveUpload(dir, name string, src io.Reader) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(f, src)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			// Don't leave half a file behind
+			os.Remove(f.Name())
+			return "", err
+		}
+		return candidate, nil
+	}
+}
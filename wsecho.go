@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsUpgrader accepts any origin, since -ws-echo is a debug target for apps
+// served from elsewhere too.
+var wsUpgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+// hijacker exposes the connection below the middleware's writers, which
+// gorilla/websocket only looks for on the writer it's given.
+type hijacker struct {
+	http.ResponseWriter
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
+
+// serveWSEcho upgrades to a WebSocket and sends every message back as it
+// came, logging each, as a stand-in for an app's backend during
+// development.
+func serveWSEcho(w http.ResponseWriter, r *http.Request) {
+	// Upgrade answers the client itself when it fails
+	conn, err := wsUpgrader.Upgrade(hijacker{w}, r, nil)
+	if err != nil {
+		log.Println("WebSocket echo:", err)
+		return
+	}
+	defer conn.Close()
+	// The connection stays open as long as the client's, well past
+	// -write-timeout
+	conn.NetConn().SetDeadline(time.Time{})
+
+	remote := clientIP(r)
+	log.Printf("WebSocket echo: %s connected", remote)
+	for {
+		kind, msg, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("WebSocket echo: %s disconnected: %v", remote, err)
+			return
+		}
+		if kind == websocket.TextMessage {
+			log.Printf("WebSocket echo: %s sent %q", remote, msg)
+		} else {
+			log.Printf("WebSocket echo: %s sent %d binary bytes", remote, len(msg))
+		}
+		if err := conn.WriteMessage(kind, msg); err != nil {
+			log.Printf("WebSocket echo: %s: %v", remote, err)
+			return
+		}
+	}
+}